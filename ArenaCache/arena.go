@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"hash/maphash"
+	"math"
+)
+
+var ErrTooLarge = errors.New("entry does not fit in a slot")
+
+// slot metadata, kept out of the slabs and free of pointers so the garbage
+// collector never has to scan it
+type slot struct {
+	hash uint64
+	klen uint16
+	vlen uint32
+	used bool
+	ref  bool // CLOCK reference bit, set on every hit
+}
+
+type Option func(*options)
+
+type options struct {
+	slabSlots int
+}
+
+// WithSlabSlots sets how many slots share one slab allocation, 4096 by
+// default.
+func WithSlabSlots(n int) Option {
+	return func(o *options) {
+		o.slabSlots = n
+	}
+}
+
+// ArenaCache stores string keys and []byte values in fixed size slots carved
+// out of preallocated slabs, evicting with CLOCK. The index maps key hashes
+// to slot numbers and neither it nor the slot metadata holds a pointer, so
+// millions of entries cost the garbage collector a handful of slab headers
+// instead of a node per entry.
+//
+// Two keys with the same 64 bit hash share a slot, the later Put replaces
+// the earlier entry.
+type ArenaCache struct {
+	slotSize  int
+	slabSlots int
+	slabs     [][]byte
+	meta      []slot
+	index     map[uint64]uint32
+	free      []uint32
+	next      uint32 // slots from here on were never used
+	hand      uint32
+	seed      maphash.Seed
+	count     int
+}
+
+// NewArenaCache allocates room for capacity entries up front, each entry's
+// key and value together taking at most slotSize bytes.
+func NewArenaCache(capacity, slotSize int, opts ...Option) (*ArenaCache, error) {
+	if capacity <= 0 || capacity > math.MaxUint32 {
+		return nil, errors.New("capacity must be positive")
+	}
+	if slotSize <= 0 {
+		return nil, errors.New("slot size must be positive")
+	}
+	o := options{slabSlots: 4096}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.slabSlots <= 0 {
+		return nil, errors.New("slab slots must be positive")
+	}
+	c := &ArenaCache{
+		slotSize:  slotSize,
+		slabSlots: o.slabSlots,
+		meta:      make([]slot, capacity),
+		index:     make(map[uint64]uint32, capacity),
+		seed:      maphash.MakeSeed(),
+	}
+	for left := capacity; left > 0; left -= o.slabSlots {
+		c.slabs = append(c.slabs, make([]byte, min(left, o.slabSlots)*slotSize))
+	}
+	return c, nil
+}
+
+func (c *ArenaCache) slotBytes(i uint32) []byte {
+	off := int(i) % c.slabSlots * c.slotSize
+	return c.slabs[int(i)/c.slabSlots][off : off+c.slotSize]
+}
+
+func (c *ArenaCache) lookup(key string) (uint32, bool) {
+	i, ok := c.index[maphash.String(c.seed, key)]
+	if !ok {
+		return 0, false
+	}
+	// a hash collision leaves another key in the slot
+	s := &c.meta[i]
+	return i, string(c.slotBytes(i)[:s.klen]) == key
+}
+
+// Get returns a copy of the value, the slot may be reused by a later Put.
+func (c *ArenaCache) Get(key string) ([]byte, bool) {
+	return c.GetInto(nil, key)
+}
+
+// GetInto appends the value to dst, so a caller reusing a buffer reads
+// without allocating.
+func (c *ArenaCache) GetInto(dst []byte, key string) ([]byte, bool) {
+	i, ok := c.lookup(key)
+	if !ok {
+		return dst, false
+	}
+	s := &c.meta[i]
+	s.ref = true
+	b := c.slotBytes(i)
+	return append(dst, b[s.klen:int(s.klen)+int(s.vlen)]...), true
+}
+
+// Put copies key and value into a slot, evicting an entry if the cache is
+// full.
+func (c *ArenaCache) Put(key string, value []byte) error {
+	if len(key) > math.MaxUint16 || len(key)+len(value) > c.slotSize {
+		return ErrTooLarge
+	}
+	h := maphash.String(c.seed, key)
+	i, exists := c.index[h]
+	if !exists {
+		i = c.alloc()
+		c.index[h] = i
+		c.count++
+	}
+	b := c.slotBytes(i)
+	copy(b, key)
+	copy(b[len(key):], value)
+	c.meta[i] = slot{hash: h, klen: uint16(len(key)), vlen: uint32(len(value)), used: true, ref: exists}
+	return nil
+}
+
+func (c *ArenaCache) alloc() uint32 {
+	if n := len(c.free); n > 0 {
+		i := c.free[n-1]
+		c.free = c.free[:n-1]
+		return i
+	}
+	if int(c.next) < len(c.meta) {
+		c.next++
+		return c.next - 1
+	}
+	return c.evict()
+}
+
+// CLOCK: sweep the hand over the slots, giving every referenced entry a
+// second chance, and take the first one that wasn't used since the last pass
+func (c *ArenaCache) evict() uint32 {
+	for {
+		i := c.hand
+		if c.hand++; int(c.hand) == len(c.meta) {
+			c.hand = 0
+		}
+		s := &c.meta[i]
+		if !s.used {
+			continue
+		}
+		if s.ref {
+			s.ref = false
+			continue
+		}
+		delete(c.index, s.hash)
+		s.used = false
+		c.count--
+		return i
+	}
+}
+
+func (c *ArenaCache) Remove(key string) bool {
+	i, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	s := &c.meta[i]
+	delete(c.index, s.hash)
+	s.used = false
+	c.free = append(c.free, i)
+	c.count--
+	return true
+}
+
+func (c *ArenaCache) Len() int {
+	return c.count
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"hash/maphash"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestArenaCache(t *testing.T) {
+	c, err := NewArenaCache(4, 16, WithSlabSlots(3))
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	tests := []struct {
+		name  string
+		op    func() error
+		key   string
+		want  string
+		found bool
+	}{
+		{"put", func() error { return c.Put("a", []byte("one")) }, "a", "one", true},
+		{"overwrite", func() error { return c.Put("a", []byte("uno")) }, "a", "uno", true},
+		{"empty value", func() error { return c.Put("b", nil) }, "b", "", true},
+		{"second slab", func() error {
+			c.Put("c", []byte("three"))
+			return c.Put("d", []byte("four"))
+		}, "d", "four", true},
+		{"fills the slot", func() error { return c.Put("e", []byte("fifteen-bytes!!")) }, "e", "fifteen-bytes!!", true},
+		{"too large", func() error { return c.Put("f", []byte("sixteen-bytes!!!")) }, "f", "", false},
+		{"remove", func() error {
+			if !c.Remove("e") {
+				return errors.New("e not removed")
+			}
+			return nil
+		}, "e", "", false},
+	}
+	for _, tt := range tests {
+		err := tt.op()
+		if err != nil && !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, ok := c.Get(tt.key)
+		if ok != tt.found || string(got) != tt.want {
+			t.Errorf("%s: expected %q %v but got %q %v", tt.name, tt.want, tt.found, got, ok)
+		}
+	}
+	if c.Len() != 3 {
+		t.Errorf("expected 3 entries but got %d", c.Len())
+	}
+}
+
+func TestArenaCache_Clock(t *testing.T) {
+	c, _ := NewArenaCache(3, 8)
+	for _, k := range []string{"a", "b", "c"} {
+		c.Put(k, []byte(k))
+	}
+	c.Get("a")
+	c.Put("d", []byte("d")) // a gets a second chance, b goes
+	c.Put("e", []byte("e")) // c goes
+	c.Put("f", []byte("f")) // a's chance is used up
+	for k, want := range map[string]bool{"a": false, "b": false, "c": false, "d": true, "e": true, "f": true} {
+		if _, ok := c.Get(k); ok != want {
+			t.Errorf("key %s: expected cached %v", k, want)
+		}
+	}
+}
+
+func TestArenaCache_HashCollision(t *testing.T) {
+	c, _ := NewArenaCache(2, 8)
+	c.Put("a", []byte("1"))
+	// point another key's hash at a's slot
+	c.index[maphash.String(c.seed, "x")] = c.index[maphash.String(c.seed, "a")]
+	if _, ok := c.Get("x"); ok {
+		t.Errorf("expected a colliding key to miss")
+	}
+	if c.Remove("x") {
+		t.Errorf("expected a colliding key not to remove the occupant")
+	}
+}
+
+func TestArenaCache_MatchesModel(t *testing.T) {
+	const capacity = 64
+	c, _ := NewArenaCache(capacity, 32, WithSlabSlots(10))
+	model := map[string][]byte{}
+	rnd := rand.New(rand.NewSource(1))
+	var buf []byte
+	for i := 0; i < 20000; i++ {
+		key := strconv.Itoa(rnd.Intn(200))
+		switch rnd.Intn(4) {
+		case 0:
+			c.Remove(key)
+			delete(model, key)
+		case 1:
+			v := []byte(strconv.Itoa(i))
+			c.Put(key, v)
+			model[key] = v
+		default:
+			var ok bool
+			if buf, ok = c.GetInto(buf[:0], key); ok && !bytes.Equal(buf, model[key]) {
+				t.Fatalf("op %d: expected %q for %s but got %q", i, model[key], key, buf)
+			}
+		}
+		if c.Len() > capacity || c.Len() != len(c.index) {
+			t.Fatalf("op %d: %d entries, %d indexed", i, c.Len(), len(c.index))
+		}
+	}
+}
+
+func TestArenaCache_GetIntoDoesNotAllocate(t *testing.T) {
+	c, _ := NewArenaCache(16, 64)
+	c.Put("key", []byte("value"))
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = c.GetInto(buf[:0], "key")
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations but got %v", allocs)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// pointerLRU is the shape of LRUCache, a map of pointers to nodes linked both
+// ways, kept here to benchmark against since LRUCache lives in its own
+// package. Every entry is a node, a key string and a value slice for the
+// garbage collector to trace.
+type pointerLRU struct {
+	capacity   int
+	cache      map[string]*lruNode
+	head, tail *lruNode
+}
+
+type lruNode struct {
+	key        string
+	value      []byte
+	prev, next *lruNode
+}
+
+func newPointerLRU(capacity int) *pointerLRU {
+	c := &pointerLRU{capacity: capacity, cache: make(map[string]*lruNode, capacity)}
+	c.head, c.tail = &lruNode{}, &lruNode{}
+	c.head.next, c.tail.prev = c.tail, c.head
+	return c
+}
+
+func (c *pointerLRU) unlink(n *lruNode) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+}
+
+func (c *pointerLRU) pushFront(n *lruNode) {
+	n.prev, n.next = c.head, c.head.next
+	c.head.next.prev = n
+	c.head.next = n
+}
+
+func (c *pointerLRU) Get(key string) ([]byte, bool) {
+	n, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	c.unlink(n)
+	c.pushFront(n)
+	return n.value, true
+}
+
+func (c *pointerLRU) Put(key string, value []byte) error {
+	if n, ok := c.cache[key]; ok {
+		n.value = value
+		c.unlink(n)
+		c.pushFront(n)
+		return nil
+	}
+	if len(c.cache) >= c.capacity {
+		last := c.tail.prev
+		c.unlink(last)
+		delete(c.cache, last.key)
+	}
+	n := &lruNode{key: key, value: value}
+	c.cache[key] = n
+	c.pushFront(n)
+	return nil
+}
+
+type byteCache interface {
+	Get(key string) ([]byte, bool)
+	Put(key string, value []byte) error
+}
+
+const (
+	benchEntries = 1 << 20
+	benchValue   = 32
+)
+
+func benchKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func fill(c byteCache, keys []string) {
+	for _, k := range keys {
+		c.Put(k, make([]byte, benchValue))
+	}
+}
+
+// with the cache full, time forced collections and report the stop the world
+// pauses they took alongside, a full mark traces everything the cache holds
+func benchmarkGC(b *testing.B, c byteCache) {
+	keys := benchKeys(benchEntries)
+	fill(c, keys)
+	keys = nil
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	elapsed := time.Since(start)
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+
+	gcs := float64(after.NumGC - before.NumGC)
+	b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N), "ns/gc")
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/gcs, "pause-ns/gc")
+	b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+	runtime.KeepAlive(c)
+}
+
+func BenchmarkGC_Arena(b *testing.B) {
+	c, _ := NewArenaCache(benchEntries, 64)
+	benchmarkGC(b, c)
+}
+
+func BenchmarkGC_PointerLRU(b *testing.B) {
+	benchmarkGC(b, newPointerLRU(benchEntries))
+}
+
+// a cache a quarter the size of the key space, every miss is a Put
+func benchmarkMixed(b *testing.B, c byteCache) {
+	keys := benchKeys(1 << 16)
+	value := make([]byte, benchValue)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[(i*7919)&(len(keys)-1)]
+		if _, ok := c.Get(key); !ok {
+			c.Put(key, value)
+		}
+	}
+}
+
+func BenchmarkMixed_Arena(b *testing.B) {
+	c, _ := NewArenaCache(1<<14, 64)
+	benchmarkMixed(b, c)
+}
+
+func BenchmarkMixed_PointerLRU(b *testing.B) {
+	benchmarkMixed(b, newPointerLRU(1<<14))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"cacheEvicitonPolicies/internal/frame"
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+// the log is only rewritten once it holds at least this much garbage
+const compactMin = 1 << 20
+
+type diskRecord[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// where a key's latest record sits in the log, entries are also chained in
+// write order so the oldest one is evicted first
+type diskEntry[K comparable] struct {
+	key        K
+	off        int64
+	n          int64
+	prev, next *diskEntry[K]
+}
+
+// diskTier is a log-structured store: records are only ever appended, an
+// in-memory index points at the live ones, and the file is rewritten
+// without the dead ones once they make up most of it. It starts empty, the
+// file is scratch space rather than persistence.
+type diskTier[K comparable, V any] struct {
+	path       string
+	file       *os.File
+	codec      snapshot.Codec
+	capacity   int
+	index      map[K]*diskEntry[K]
+	head       diskEntry[K] // sentinel, head.next is the oldest entry
+	size       int64        // end of the log
+	live       int64        // bytes of records still indexed
+	compactMin int64
+}
+
+func openDiskTier[K comparable, V any](path string, capacity int, codec snapshot.Codec) (*diskTier[K, V], error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	d := &diskTier[K, V]{
+		path:       path,
+		file:       f,
+		codec:      codec,
+		capacity:   capacity,
+		index:      make(map[K]*diskEntry[K]),
+		compactMin: compactMin,
+	}
+	d.head.next = &d.head
+	d.head.prev = &d.head
+	return d, nil
+}
+
+// stores the entry and returns how many older entries were evicted for it
+func (d *diskTier[K, V]) put(key K, value V) (int, error) {
+	var payload bytes.Buffer
+	if err := d.codec.NewEncoder(&payload).Encode(diskRecord[K, V]{Key: key, Value: value}); err != nil {
+		return 0, err
+	}
+	buf := frame.Append(make([]byte, 0, frame.HeaderSize+payload.Len()), payload.Bytes())
+	if _, err := d.file.WriteAt(buf, d.size); err != nil {
+		return 0, err
+	}
+
+	d.remove(key)
+	evicted := 0
+	for len(d.index) >= d.capacity && d.head.next != &d.head {
+		d.drop(d.head.next)
+		evicted++
+	}
+	e := &diskEntry[K]{key: key, off: d.size, n: int64(len(buf))}
+	d.index[key] = e
+	e.prev = d.head.prev
+	e.next = &d.head
+	d.head.prev.next = e
+	d.head.prev = e
+	d.size += e.n
+	d.live += e.n
+	return evicted, d.maybeCompact()
+}
+
+func (d *diskTier[K, V]) get(key K) (V, bool, error) {
+	var zero V
+	e, ok := d.index[key]
+	if !ok {
+		return zero, false, nil
+	}
+	rec, err := d.read(e)
+	if err != nil {
+		return zero, false, err
+	}
+	return rec.Value, true, nil
+}
+
+func (d *diskTier[K, V]) read(e *diskEntry[K]) (diskRecord[K, V], error) {
+	var rec diskRecord[K, V]
+	buf := make([]byte, e.n)
+	if _, err := d.file.ReadAt(buf, e.off); err != nil {
+		return rec, err
+	}
+	payload, n, err := frame.Next(buf)
+	if err == nil && n != len(buf) {
+		err = frame.ErrCorrupt
+	}
+	if err != nil {
+		return rec, fmt.Errorf("disk tier record: %w", err)
+	}
+	err = d.codec.NewDecoder(bytes.NewReader(payload)).Decode(&rec)
+	return rec, err
+}
+
+func (d *diskTier[K, V]) remove(key K) bool {
+	e, ok := d.index[key]
+	if ok {
+		d.drop(e)
+	}
+	return ok
+}
+
+// forgets the entry, its bytes stay in the log until the next compaction
+func (d *diskTier[K, V]) drop(e *diskEntry[K]) {
+	delete(d.index, e.key)
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	d.live -= e.n
+}
+
+func (d *diskTier[K, V]) maybeCompact() error {
+	garbage := d.size - d.live
+	if garbage < d.compactMin || garbage < d.live {
+		return nil
+	}
+	return d.compact()
+}
+
+// copies the live records, oldest first, into a fresh file that replaces
+// the log
+func (d *diskTier[K, V]) compact() error {
+	tmpPath := d.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	var off int64
+	for e := d.head.next; e != &d.head; e = e.next {
+		buf := make([]byte, e.n)
+		if _, err = d.file.ReadAt(buf, e.off); err != nil {
+			break
+		}
+		if _, err = tmp.WriteAt(buf, off); err != nil {
+			break
+		}
+		e.off = off
+		off += e.n
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, d.path)
+	}
+	if err != nil {
+		// offsets may already point into the new file, start over empty
+		tmp.Close()
+		os.Remove(tmpPath)
+		d.reset()
+		return err
+	}
+	d.file.Close()
+	d.file = tmp
+	d.size = off
+	d.live = off
+	return nil
+}
+
+func (d *diskTier[K, V]) reset() {
+	clear(d.index)
+	d.head.next = &d.head
+	d.head.prev = &d.head
+	d.live = 0
+}
+
+func (d *diskTier[K, V]) len() int {
+	return len(d.index)
+}
+
+func (d *diskTier[K, V]) close() error {
+	err := d.file.Close()
+	if rmErr := os.Remove(d.path); err == nil {
+		err = rmErr
+	}
+	return err
+}
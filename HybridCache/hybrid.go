@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+// Memory is the in-memory policy in front of the disk tier. LRUCache,
+// LFUCache and MRUCache all fit, OnEvict is how evicted entries reach disk.
+type Memory[K comparable, V any] interface {
+	Get(key K) (V, bool)
+	Put(key K, value V)
+	Remove(key K) bool
+	OnEvict(fn func(key K, value V))
+}
+
+// Stats counts lookups per tier and the traffic between them.
+type Stats struct {
+	MemoryHits    int64
+	DiskHits      int64
+	Misses        int64
+	Demotions     int64 // memory evictions written to disk
+	Promotions    int64 // disk hits moved back into memory
+	DiskEvictions int64
+	DiskErrors    int64 // demotions or reads that failed, the entry is lost
+	DiskEntries   int
+	DiskBytes     int64 // size of the log file, dead records included
+}
+
+// MemoryHitRatio is the share of all lookups answered from memory.
+func (s Stats) MemoryHitRatio() float64 {
+	return ratio(s.MemoryHits, s.MemoryHits+s.DiskHits+s.Misses)
+}
+
+// DiskHitRatio is the share of memory misses answered from disk.
+func (s Stats) DiskHitRatio() float64 {
+	return ratio(s.DiskHits, s.DiskHits+s.Misses)
+}
+
+func ratio(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+type Option func(*options)
+
+type options struct {
+	codec snapshot.Codec
+}
+
+// WithCodec picks how entries are encoded on disk, gob by default.
+func WithCodec(codec snapshot.Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// HybridCache keeps the hot entries in a memory policy and the ones it
+// evicts in a bounded disk tier, a disk hit moves the entry back to memory.
+// An entry lives in exactly one tier at a time.
+type HybridCache[K comparable, V any] struct {
+	mu    sync.Mutex
+	mem   Memory[K, V]
+	disk  *diskTier[K, V]
+	stats Stats
+}
+
+// NewHybridCache takes over mem's eviction callback and keeps up to
+// diskCapacity demoted entries in the file at path, which is truncated.
+func NewHybridCache[K comparable, V any](mem Memory[K, V], path string, diskCapacity int, opts ...Option) (*HybridCache[K, V], error) {
+	if diskCapacity <= 0 {
+		return nil, errors.New("capacity must be positive")
+	}
+	o := options{codec: snapshot.Gob}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	disk, err := openDiskTier[K, V](path, diskCapacity, o.codec)
+	if err != nil {
+		return nil, err
+	}
+	c := &HybridCache[K, V]{mem: mem, disk: disk}
+	mem.OnEvict(c.demote)
+	return c, nil
+}
+
+// called by the memory tier from inside Put, so with c.mu held
+func (c *HybridCache[K, V]) demote(key K, value V) {
+	evicted, err := c.disk.put(key, value)
+	c.stats.DiskEvictions += int64(evicted)
+	if err != nil {
+		c.stats.DiskErrors++
+		return
+	}
+	c.stats.Demotions++
+}
+
+func (c *HybridCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.mem.Get(key); ok {
+		c.stats.MemoryHits++
+		return v, true
+	}
+	v, ok, err := c.disk.get(key)
+	if err != nil {
+		c.stats.DiskErrors++
+		c.disk.remove(key)
+	}
+	if !ok {
+		c.stats.Misses++
+		return v, false
+	}
+	c.stats.DiskHits++
+	c.stats.Promotions++
+	c.disk.remove(key)
+	c.mem.Put(key, v)
+	return v, true
+}
+
+func (c *HybridCache[K, V]) Put(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the disk copy would be stale once memory holds the new value
+	c.disk.remove(key)
+	c.mem.Put(key, value)
+}
+
+func (c *HybridCache[K, V]) Remove(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	inMem := c.mem.Remove(key)
+	onDisk := c.disk.remove(key)
+	return inMem || onDisk
+}
+
+func (c *HybridCache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.DiskEntries = c.disk.len()
+	s.DiskBytes = c.disk.size
+	return s
+}
+
+// Close deletes the disk tier's file, the memory tier is left as it is.
+func (c *HybridCache[K, V]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.disk.close()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"cacheEvicitonPolicies/internal/cachetest"
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+func newTestHybrid(t *testing.T, memCap, diskCap int, opts ...Option) (*HybridCache[string, int], *cachetest.LRU[string, int]) {
+	t.Helper()
+	mem := cachetest.NewLRU[string, int](memCap)
+	c, err := NewHybridCache[string, int](mem, filepath.Join(t.TempDir(), "disk.log"), diskCap, opts...)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, mem
+}
+
+func TestHybridCache_DemoteAndPromote(t *testing.T) {
+	for _, codec := range []snapshot.Codec{snapshot.Gob, snapshot.JSON} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			c, mem := newTestHybrid(t, 2, 10, WithCodec(codec))
+			c.Put("a", 1)
+			c.Put("b", 2)
+			c.Put("c", 3) // a goes to disk
+
+			if mem.Has("a") {
+				t.Fatalf("expected a demoted out of memory")
+			}
+			if v, ok := c.Get("a"); !ok || v != 1 {
+				t.Fatalf("expected a served from disk but got %v %v", v, ok)
+			}
+			if !mem.Has("a") {
+				t.Errorf("expected a promoted back to memory")
+			}
+			c.Get("a")
+			c.Get("zzz")
+
+			got := c.Stats()
+			want := Stats{MemoryHits: 1, DiskHits: 1, Misses: 1, Demotions: 2, Promotions: 1, DiskEntries: 1}
+			got.DiskBytes = 0
+			if got != want {
+				t.Errorf("expected %+v but got %+v", want, got)
+			}
+			if r := got.MemoryHitRatio(); r < 0.33 || r > 0.34 {
+				t.Errorf("expected a memory hit ratio of 1/3 but got %v", r)
+			}
+			if r := got.DiskHitRatio(); r != 0.5 {
+				t.Errorf("expected a disk hit ratio of 1/2 but got %v", r)
+			}
+		})
+	}
+}
+
+func TestHybridCache_DiskCapacity(t *testing.T) {
+	c, _ := newTestHybrid(t, 1, 3)
+	for i := 0; i < 6; i++ {
+		c.Put(strconv.Itoa(i), i)
+	}
+	// 5 is in memory, 2..4 on disk, 0 and 1 were the oldest on disk
+	for i := 0; i < 6; i++ {
+		_, ok := c.disk.index[strconv.Itoa(i)]
+		if want := i >= 2 && i <= 4; ok != want {
+			t.Errorf("key %d on disk: expected %v", i, want)
+		}
+	}
+	if s := c.Stats(); s.DiskEvictions != 2 || s.DiskEntries != 3 {
+		t.Errorf("expected 2 disk evictions and 3 entries but got %+v", s)
+	}
+}
+
+func TestHybridCache_PutAndRemove(t *testing.T) {
+	c, _ := newTestHybrid(t, 1, 5)
+	c.Put("a", 1)
+	c.Put("b", 2) // a on disk
+	c.Put("a", 10)
+	if _, ok := c.disk.index["a"]; ok {
+		t.Errorf("expected Put to drop the stale disk copy")
+	}
+	if v, _ := c.Get("a"); v != 10 {
+		t.Errorf("expected the new value but got %d", v)
+	}
+	if !c.Remove("b") || c.Remove("b") {
+		t.Errorf("expected b removed from disk exactly once")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected b gone")
+	}
+}
+
+func TestHybridCache_Compaction(t *testing.T) {
+	c, _ := newTestHybrid(t, 1, 4)
+	c.disk.compactMin = 512
+	for i := 0; i < 500; i++ {
+		c.Put(strconv.Itoa(i%8), i)
+	}
+	s := c.Stats()
+	if s.DiskBytes > 4*c.disk.live {
+		t.Errorf("expected compaction to keep garbage bounded, log is %d bytes for %d live", s.DiskBytes, c.disk.live)
+	}
+	info, err := os.Stat(c.disk.path)
+	if err != nil || info.Size() < c.disk.size {
+		t.Fatalf("expected the compacted log at its path: %v", err)
+	}
+	for key := range c.disk.index {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected %s readable after compaction", key)
+		}
+	}
+}
+
+func TestHybridCache_CorruptRecord(t *testing.T) {
+	c, _ := newTestHybrid(t, 1, 5)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	e := c.disk.index["a"]
+	c.disk.file.WriteAt([]byte{0xff, 0xff}, e.off+e.n-2)
+
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected a corrupt record to read as a miss")
+	}
+	if s := c.Stats(); s.DiskErrors != 1 || s.DiskEntries != 0 {
+		t.Errorf("expected the corrupt entry counted and dropped but got %+v", s)
+	}
+}
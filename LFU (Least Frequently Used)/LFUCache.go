@@ -1,33 +1,88 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
+)
 
 type Node[K comparable, V any] struct {
 	key        K
 	value      V
 	freq       int
+	expireAt   int64 // unix nanos, 0 when the entry has no ttl
+	heapIdx    int
+	seq        uint64          // insertion order, for FIFO tie breaks
+	parent     *freqNode[K, V] // bucket the node sits in
 	prev, next *Node[K, V]
 }
 type DLL[K comparable, V any] struct {
 	head, tail *Node[K, V]
 }
+
+// freqNode is one bucket of the frequency list, holding every key used
+// exactly freq times, most recently bumped first
+type freqNode[K comparable, V any] struct {
+	freq       int
+	items      *DLL[K, V]
+	prev, next *freqNode[K, V]
+}
+
 type LFUCache[K comparable, V any] struct {
 	capacity int
-	minFreq  int
 	cache    map[K]*Node[K, V]
-	freqMap  map[int]*DLL[K, V]
+	freqs    freqNode[K, V]  // sentinel of the ascending frequency list, next is the lowest
+	spare    *freqNode[K, V] // emptied buckets kept for reuse, linked through next
+	expiries expiryHeap[K, V]
+	clock    clock.Clock
+	tie      TieBreak
+	maxFreq  int // 0 for no cap
+	sizer    func(V) int
+	rnd      *rand.Rand
+	seq      uint64
+	codec    snapshot.Codec
+	onEvict  func(key K, value V)
+}
+
+type Option func(*options)
+
+type options struct {
+	clock   clock.Clock
+	tie     TieBreak
+	maxFreq int
+	codec   snapshot.Codec
+}
+
+// WithClock swaps the wall clock used for ttl expiry, mostly for a clock.Fake
+// in tests.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
 }
 
-func NewLFUCache[K comparable, V any](capacity int) (*LFUCache[K, V], error) {
+func NewLFUCache[K comparable, V any](capacity int, opts ...Option) (*LFUCache[K, V], error) {
 	if capacity <= 0 {
 		return nil, errors.New("capacity must be positive")
 	}
+	o := options{clock: clock.Real, codec: snapshot.Gob}
+	for _, opt := range opts {
+		opt(&o)
+	}
 	cache := &LFUCache[K, V]{
 		capacity: capacity,
-		minFreq:  0,
 		cache:    make(map[K]*Node[K, V]),
-		freqMap:  make(map[int]*DLL[K, V]),
+		clock:    o.clock,
+		tie:      o.tie,
+		maxFreq:  o.maxFreq,
+		rnd:      newTieRand(o.tie),
+		codec:    o.codec,
 	}
+	cache.freqs.next = &cache.freqs
+	cache.freqs.prev = &cache.freqs
 	return cache, nil
 }
 func NewDLL[K comparable, V any]() *DLL[K, V] {
@@ -68,41 +123,70 @@ func (dll *DLL[K, V]) addFront(node *Node[K, V]) {
 	dll.head.next.prev = node
 	dll.head.next = node
 }
-func (lfu *LFUCache[K, V]) addNodeToFreqList(freq int, node *Node[K, V]) {
-	if dll, exists := lfu.freqMap[freq]; exists {
-		dll.addFront(node)
+
+// bucket for freq right after prev, reusing an emptied one when there is one
+func (lfu *LFUCache[K, V]) insertFreqNode(prev *freqNode[K, V], freq int) *freqNode[K, V] {
+	fn := lfu.spare
+	if fn != nil {
+		lfu.spare = fn.next
 	} else {
-		newList := NewDLL[K, V]()
-		newList.addFront(node)
-		lfu.freqMap[freq] = newList
+		fn = &freqNode[K, V]{items: NewDLL[K, V]()}
 	}
+	fn.freq = freq
+	fn.prev = prev
+	fn.next = prev.next
+	prev.next.prev = fn
+	prev.next = fn
+	return fn
 }
-func (lfu *LFUCache[K, V]) removeNodeFromFreqList(node *Node[K, V]) {
-	if dll, exists := lfu.freqMap[node.freq]; exists {
-		dll.removeNode(node)
-		if dll.isEmpty() {
-			delete(lfu.freqMap, node.freq)
-		}
+
+// takes the node out of its bucket, the bucket goes to the spares once empty
+func (lfu *LFUCache[K, V]) unlinkNode(node *Node[K, V]) {
+	fn := node.parent
+	fn.items.removeNode(node)
+	node.parent = nil
+	if fn.items.isEmpty() {
+		fn.prev.next = fn.next
+		fn.next.prev = fn.prev
+		fn.prev = nil
+		fn.next = lfu.spare
+		lfu.spare = fn
 	}
 }
-func (lfu *LFUCache[K, V]) updateMinFreq() {
-	for {
-		if dll, exists := lfu.freqMap[lfu.minFreq]; !exists || dll.isEmpty() {
-			lfu.minFreq++
-		} else {
-			break
-		}
+
+// moves the node to the bucket for freq+1, creating it next to the current
+// one if needed
+func (lfu *LFUCache[K, V]) bump(node *Node[K, V]) {
+	cur := node.parent
+	if lfu.maxFreq > 0 && node.freq >= lfu.maxFreq {
+		// capped, only its recency within the bucket changes
+		cur.items.removeNode(node)
+		cur.items.addFront(node)
+		return
+	}
+	next := cur.next
+	if next == &lfu.freqs || next.freq != node.freq+1 {
+		next = lfu.insertFreqNode(cur, node.freq+1)
 	}
+	lfu.unlinkNode(node)
+	node.freq++
+	node.parent = next
+	next.items.addFront(node)
+}
+
+// lowest frequency held, 0 when empty
+func (lfu *LFUCache[K, V]) minFreq() int {
+	return lfu.freqs.next.freq
 }
+
 func (lfu *LFUCache[K, V]) Get(key K) (V, bool) {
 	if node, exists := lfu.cache[key]; exists {
-		oldFreq := node.freq
-		lfu.removeNodeFromFreqList(node)
-		node.freq++
-		lfu.addNodeToFreqList(node.freq, node)
-		if oldFreq == lfu.minFreq {
-			lfu.updateMinFreq()
+		if node.expired(lfu.clock.Now().UnixNano()) {
+			lfu.removeEntry(node)
+			var zero V
+			return zero, false
 		}
+		lfu.bump(node)
 		return node.value, true
 	}
 	var zero V
@@ -110,42 +194,110 @@ func (lfu *LFUCache[K, V]) Get(key K) (V, bool) {
 }
 func (lfu *LFUCache[K, V]) Put(key K, value V) {
 	if node, exists := lfu.cache[key]; exists {
-		node.value = value
-		oldFreq := node.freq
-		node.freq++
-		lfu.removeNodeFromFreqList(node)
-		lfu.addNodeToFreqList(node.freq, node)
-		if oldFreq == lfu.minFreq {
-			lfu.updateMinFreq()
+		// an expired entry already reads as a miss, the write starts it afresh
+		if !node.expired(lfu.clock.Now().UnixNano()) {
+			node.value = value
+			lfu.bump(node)
+			return
 		}
-		return
+		lfu.removeEntry(node)
 	}
 	if len(lfu.cache) >= lfu.capacity {
-		if dll, exists := lfu.freqMap[lfu.minFreq]; exists {
-			tbRemoved := dll.removeLast()
-			if tbRemoved != nil {
-				delete(lfu.cache, tbRemoved.key)
-			}
-			if dll.isEmpty() {
-				delete(lfu.freqMap, lfu.minFreq)
-			}
+		// an expired entry goes before the least frequently used live one
+		if expired := lfu.expiries.expired(lfu.clock.Now().UnixNano()); expired != nil {
+			lfu.removeEntry(expired)
+		} else {
+			lfu.evict()
 		}
 	}
-	newNode := &Node[K, V]{key: key, value: value, freq: 1}
+	first := lfu.freqs.next
+	if first == &lfu.freqs || first.freq != 1 {
+		first = lfu.insertFreqNode(&lfu.freqs, 1)
+	}
+	lfu.seq++
+	newNode := &Node[K, V]{key: key, value: value, freq: 1, heapIdx: -1, seq: lfu.seq, parent: first}
 	lfu.cache[key] = newNode
-	lfu.addNodeToFreqList(1, newNode)
-	lfu.minFreq = 1
+	first.items.addFront(newNode)
+}
+
+// PutWithTTL stores the entry like Put and expires it after ttl. A later Put
+// of the same key keeps the ttl until it runs out.
+func (lfu *LFUCache[K, V]) PutWithTTL(key K, value V, ttl time.Duration) {
+	lfu.Put(key, value)
+	node := lfu.cache[key]
+	node.expireAt = lfu.clock.Now().Add(ttl).UnixNano()
+	lfu.expiries.set(node)
+}
+
+// DeleteExpired proactively drops every expired entry and returns how many
+// went, without it they are only dropped when read or evicted.
+func (lfu *LFUCache[K, V]) DeleteExpired() int {
+	now := lfu.clock.Now().UnixNano()
+	n := 0
+	for node := lfu.expiries.expired(now); node != nil; node = lfu.expiries.expired(now) {
+		lfu.removeEntry(node)
+		n++
+	}
+	return n
+}
+
+// OnEvict registers fn to be called with every live entry pushed out to make
+// room. Expired entries and explicit removals don't count.
+func (lfu *LFUCache[K, V]) OnEvict(fn func(key K, value V)) {
+	lfu.onEvict = fn
+}
+
+// Remove deletes key and reports whether it was present.
+func (lfu *LFUCache[K, V]) Remove(key K) bool {
+	node, exists := lfu.cache[key]
+	if !exists {
+		return false
+	}
+	lfu.removeEntry(node)
+	return true
+}
+
+// Peek returns the value for key without counting it as an access.
+func (lfu *LFUCache[K, V]) Peek(key K) (V, bool) {
+	node, exists := lfu.cache[key]
+	if !exists || node.expired(lfu.clock.Now().UnixNano()) {
+		var zero V
+		return zero, false
+	}
+	return node.value, true
+}
+
+// Len counts the entries held, including expired ones not dropped yet.
+func (lfu *LFUCache[K, V]) Len() int {
+	return len(lfu.cache)
+}
+
+func (lfu *LFUCache[K, V]) Clear() {
+	lfu.cache = make(map[K]*Node[K, V])
+	lfu.freqs.next = &lfu.freqs
+	lfu.freqs.prev = &lfu.freqs
+	lfu.spare = nil
+	lfu.expiries = nil
+}
+
+// unlinks the node from its bucket, the map and the expiry heap
+func (lfu *LFUCache[K, V]) removeEntry(node *Node[K, V]) {
+	lfu.unlinkNode(node)
+	delete(lfu.cache, node.key)
+	lfu.expiries.remove(node)
+}
+
+func (n *Node[K, V]) expired(now int64) bool {
+	return n.expireAt != 0 && now > n.expireAt
 }
 
 // just a util func
 func (lfu *LFUCache[K, V]) evict() {
-	if dll, exists := lfu.freqMap[lfu.minFreq]; exists {
-		tbRemoved := dll.removeLast()
-		if tbRemoved != nil {
-			delete(lfu.cache, tbRemoved.key)
-		}
-		if dll.isEmpty() {
-			delete(lfu.freqMap, lfu.minFreq)
+	if lowest := lfu.freqs.next; lowest != &lfu.freqs {
+		node := lfu.victim(lowest)
+		lfu.removeEntry(node)
+		if lfu.onEvict != nil {
+			lfu.onEvict(node.key, node.value)
 		}
 	}
 }
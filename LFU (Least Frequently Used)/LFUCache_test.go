@@ -1,6 +1,16 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
+)
 
 func TestLFUCache_BasicOperations(t *testing.T) {
 	cache, err := NewLFUCache[int, string](2)
@@ -82,3 +92,533 @@ func TestLFUCache_Eviction(t *testing.T) {
 		})
 	}
 }
+
+func TestLFUCache_TTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(c *LFUCache[int, string], clk *clock.Fake)
+		wantEvict []int
+		wantKeep  []int
+	}{
+		{
+			name: "lazy expiry on get",
+			setup: func(c *LFUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				c.Put(2, "b")
+				clk.Advance(2 * time.Second)
+			},
+			wantEvict: []int{1},
+			wantKeep:  []int{2},
+		},
+		{
+			name: "expired evicted before lfu",
+			setup: func(c *LFUCache[int, string], clk *clock.Fake) {
+				c.Put(1, "a")
+				c.PutWithTTL(2, "b", time.Second)
+				c.Get(2)
+				c.Get(2)
+				c.Put(3, "c")
+				clk.Advance(2 * time.Second)
+				c.Put(4, "d")
+			},
+			wantEvict: []int{2},
+			wantKeep:  []int{1, 3, 4},
+		},
+		{
+			name: "min freq moves on when expired bucket empties",
+			setup: func(c *LFUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				c.Put(2, "b")
+				c.Get(2)
+				c.Put(3, "c")
+				c.Get(3)
+				clk.Advance(2 * time.Second)
+				c.Get(1)
+				c.Put(4, "d")
+				c.Put(5, "e")
+			},
+			wantEvict: []int{1, 4},
+			wantKeep:  []int{2, 3, 5},
+		},
+		{
+			name: "put after expiry starts afresh",
+			setup: func(c *LFUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				clk.Advance(2 * time.Second)
+				c.Put(1, "b")
+				clk.Advance(time.Hour)
+			},
+			wantKeep: []int{1},
+		},
+		{
+			name: "put keeps a live ttl",
+			setup: func(c *LFUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				c.Put(1, "b")
+				clk.Advance(2 * time.Second)
+			},
+			wantEvict: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			c, _ := NewLFUCache[int, string](3, WithClock(clk))
+			tt.setup(c, clk)
+			if len(c.cache) > 3 {
+				t.Fatalf("cache grew past capacity to %d", len(c.cache))
+			}
+			for _, k := range tt.wantEvict {
+				if _, ok := c.Get(k); ok {
+					t.Errorf("expected %d to be gone but still present", k)
+				}
+			}
+			for _, k := range tt.wantKeep {
+				if _, ok := c.Get(k); !ok {
+					t.Errorf("expected %d to be present", k)
+				}
+			}
+		})
+	}
+}
+
+func TestLFUCache_DeleteExpired(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewLFUCache[int, string](4, WithClock(clk))
+	c.PutWithTTL(1, "a", time.Second)
+	c.PutWithTTL(2, "b", 3*time.Second)
+	c.Put(3, "c")
+	c.Get(3)
+
+	clk.Advance(2 * time.Second)
+	if n := c.DeleteExpired(); n != 1 || len(c.cache) != 2 {
+		t.Errorf("expected 1 expired entry dropped but got %d and len %d", n, len(c.cache))
+	}
+	if c.minFreq() != 1 {
+		t.Errorf("expected minFreq 1 for key 2 but got %d", c.minFreq())
+	}
+}
+
+func TestLFUCache_TopK(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	cache, _ := NewLFUCache[string, int](10, WithClock(clk))
+	// final frequencies: a 4, b 2, c 2 (read last), d 1, e 1 but expired
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Put("c", 3)
+	cache.Put("d", 4)
+	cache.PutWithTTL("e", 5, time.Second)
+	for i := 0; i < 3; i++ {
+		cache.Get("a")
+	}
+	cache.Get("b")
+	cache.Get("c")
+	clk.Advance(2 * time.Second)
+
+	tests := []struct {
+		k    int
+		want []string
+	}{
+		{0, nil},
+		{1, []string{"a"}},
+		{3, []string{"a", "c", "b"}},
+		{10, []string{"a", "c", "b", "d"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, item := range cache.TopK(tt.k) {
+			got = append(got, item.Key)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("TopK(%d): expected %v but got %v", tt.k, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("TopK(%d): expected %v but got %v", tt.k, tt.want, got)
+				break
+			}
+		}
+	}
+
+	items := cache.Items()
+	if len(items) != 4 || items[0] != (Item[string, int]{Key: "a", Value: 1, Freq: 4}) {
+		t.Errorf("expected 4 live items led by a but got %v", items)
+	}
+	if freq, ok := cache.Frequency("b"); !ok || freq != 2 {
+		t.Errorf("expected frequency 2 for b but got %d", freq)
+	}
+	if freq, _ := cache.Frequency("b"); freq != 2 {
+		t.Errorf("expected Frequency not to count as an access but got %d", freq)
+	}
+	if _, ok := cache.Frequency("e"); ok {
+		t.Errorf("expected no frequency for an expired key")
+	}
+}
+
+// reference LFU: evicts the lowest frequency, and within it the key that
+// reached that frequency first
+type lfuModel struct {
+	capacity int
+	seq      int
+	entries  map[int]*modelEntry
+}
+
+type modelEntry struct {
+	value, freq, since int
+}
+
+func (m *lfuModel) bump(e *modelEntry) {
+	m.seq++
+	e.freq++
+	e.since = m.seq
+}
+
+func (m *lfuModel) get(key int) (int, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return 0, false
+	}
+	m.bump(e)
+	return e.value, true
+}
+
+func (m *lfuModel) put(key, value int) {
+	if e, ok := m.entries[key]; ok {
+		e.value = value
+		m.bump(e)
+		return
+	}
+	if len(m.entries) >= m.capacity {
+		victim, best := 0, (*modelEntry)(nil)
+		for k, e := range m.entries {
+			if best == nil || e.freq < best.freq || (e.freq == best.freq && e.since < best.since) {
+				victim, best = k, e
+			}
+		}
+		delete(m.entries, victim)
+	}
+	e := &modelEntry{value: value}
+	m.bump(e)
+	m.entries[key] = e
+}
+
+func (m *lfuModel) minFreq() int {
+	low := 0
+	for _, e := range m.entries {
+		if low == 0 || e.freq < low {
+			low = e.freq
+		}
+	}
+	return low
+}
+
+func TestLFUCache_MatchesModel(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		capacity := 1 + rnd.Intn(8)
+		cache, _ := NewLFUCache[int, int](capacity)
+		model := &lfuModel{capacity: capacity, entries: map[int]*modelEntry{}}
+
+		for step := 0; step < 2000; step++ {
+			key := rnd.Intn(capacity * 2)
+			switch op := rnd.Intn(10); {
+			case op < 4:
+				got, gotOK := cache.Get(key)
+				want, wantOK := model.get(key)
+				if got != want || gotOK != wantOK {
+					t.Fatalf("seed %d step %d: Get(%d) = %d %v, model says %d %v", seed, step, key, got, gotOK, want, wantOK)
+				}
+			case op < 8:
+				cache.Put(key, step)
+				model.put(key, step)
+			case op < 9:
+				_, wantOK := model.entries[key]
+				delete(model.entries, key)
+				if got := cache.Remove(key); got != wantOK {
+					t.Fatalf("seed %d step %d: Remove(%d) = %v, model says %v", seed, step, key, got, wantOK)
+				}
+			default:
+				got, gotOK := cache.Peek(key)
+				e, wantOK := model.entries[key]
+				if gotOK != wantOK || (wantOK && got != e.value) {
+					t.Fatalf("seed %d step %d: Peek(%d) = %d %v, model disagrees", seed, step, key, got, gotOK)
+				}
+			}
+
+			if cache.Len() != len(model.entries) {
+				t.Fatalf("seed %d step %d: Len %d, model has %d", seed, step, cache.Len(), len(model.entries))
+			}
+			if cache.minFreq() != model.minFreq() {
+				t.Fatalf("seed %d step %d: minFreq %d, model says %d", seed, step, cache.minFreq(), model.minFreq())
+			}
+			for fn := cache.freqs.next; fn != &cache.freqs; fn = fn.next {
+				if fn.items.isEmpty() || fn.next != &cache.freqs && fn.next.freq <= fn.freq {
+					t.Fatalf("seed %d step %d: bucket %d empty or out of order", seed, step, fn.freq)
+				}
+			}
+		}
+	}
+}
+
+func TestLFUCache_Clear(t *testing.T) {
+	cache, _ := NewLFUCache[int, string](2)
+	cache.Put(1, "A")
+	cache.PutWithTTL(2, "B", time.Minute)
+	cache.Get(1)
+	cache.Clear()
+
+	if cache.Len() != 0 || cache.minFreq() != 0 || cache.freqs.next != &cache.freqs || len(cache.expiries) != 0 {
+		t.Fatalf("expected an empty cache after Clear")
+	}
+	if _, ok := cache.Peek(1); ok {
+		t.Errorf("expected 1 gone after Clear")
+	}
+	cache.Put(3, "C")
+	cache.Put(4, "D")
+	cache.Put(5, "E")
+	if cache.Len() != 2 || cache.minFreq() != 1 {
+		t.Errorf("expected the cleared cache to fill and evict as new, got len %d minFreq %d", cache.Len(), cache.minFreq())
+	}
+}
+
+func TestLFUCache_BumpDoesNotAllocate(t *testing.T) {
+	cache, _ := NewLFUCache[int, int](4)
+	for i := 0; i < 4; i++ {
+		cache.Put(i, i)
+	}
+	cache.Get(0)
+	allocs := testing.AllocsPerRun(100, func() {
+		cache.Get(0)
+		cache.Get(1)
+		cache.Put(2, 2)
+	})
+	if allocs != 0 {
+		t.Errorf("expected frequency bumps to reuse buckets but got %v allocs per run", allocs)
+	}
+}
+
+func TestLFUCache_TieBreak(t *testing.T) {
+	// a, b and c all end up read once, b reached that bucket first and a was
+	// inserted first; c's value is the smallest
+	fill := func(c *LFUCache[string, string]) {
+		c.Put("a", "aaaa")
+		c.Put("b", "bbb")
+		c.Put("c", "c")
+		c.Get("b")
+		c.Get("a")
+		c.Get("c")
+	}
+	tests := []struct {
+		name  string
+		tie   TieBreak
+		sizer func(string) int
+		want  string
+	}{
+		{"lru", TieLRU, nil, "b"},
+		{"fifo", TieFIFO, nil, "a"},
+		{"smallest", TieSmallest, func(v string) int { return len(v) }, "c"},
+		{"smallest without sizer", TieSmallest, nil, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := NewLFUCache[string, string](3, WithTieBreak(tt.tie))
+			if tt.sizer != nil {
+				c.SetSizer(tt.sizer)
+			}
+			fill(c)
+			c.Put("d", "d")
+			if _, ok := c.Peek(tt.want); ok {
+				t.Errorf("expected %s evicted", tt.want)
+			}
+			if c.Len() != 3 {
+				t.Errorf("expected 3 entries but got %d", c.Len())
+			}
+		})
+	}
+
+	t.Run("random", func(t *testing.T) {
+		c, _ := NewLFUCache[string, string](3, WithTieBreak(TieRandom))
+		c.Put("hot", "x")
+		c.Get("hot")
+		// LRU would always drop the older of the two cold keys, random lets
+		// one linger now and then
+		lingered := false
+		for i := 0; i < 50; i++ {
+			c.Put(strconv.Itoa(i), "x")
+			if _, ok := c.Peek("hot"); !ok {
+				t.Fatalf("expected only the lowest bucket to lose keys")
+			}
+			for j := 0; j < i-1; j++ {
+				if _, ok := c.Peek(strconv.Itoa(j)); ok {
+					lingered = true
+				}
+			}
+		}
+		if !lingered {
+			t.Errorf("expected random tie breaks to spare an older cold key at least once")
+		}
+	})
+}
+
+func TestLFUCache_MaxFrequency(t *testing.T) {
+	c, _ := NewLFUCache[string, int](2, WithMaxFrequency(3))
+	c.Put("old", 1)
+	for i := 0; i < 100; i++ {
+		c.Get("old")
+	}
+	if freq, _ := c.Frequency("old"); freq != 3 {
+		t.Errorf("expected frequency capped at 3 but got %d", freq)
+	}
+
+	// a newer key catches up with the cap and, being more recent, outlives it
+	c.Put("new", 2)
+	c.Get("new")
+	c.Get("new")
+	c.Put("next", 3)
+	c.Get("next")
+	c.Get("next")
+	c.Get("next")
+	if _, ok := c.Peek("old"); ok {
+		t.Errorf("expected the capped key to be evictable once others caught up")
+	}
+	if freq, _ := c.Frequency("next"); freq != 3 {
+		t.Errorf("expected next at the cap but got %d", freq)
+	}
+}
+
+func TestLFUCache_Snapshot(t *testing.T) {
+	for _, codec := range []snapshot.Codec{snapshot.Gob, snapshot.JSON} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			src, _ := NewLFUCache[string, int](5, WithClock(clk), WithCodec(codec))
+			src.Put("a", 1)
+			src.Put("b", 2)
+			src.Put("c", 3)
+			src.PutWithTTL("gone", 0, time.Second)
+			src.PutWithTTL("d", 4, time.Minute)
+			for _, key := range []string{"a", "a", "a", "c", "b", "d"} {
+				src.Get(key)
+			}
+			clk.Advance(2 * time.Second)
+			want := src.TopK(10)
+
+			var buf bytes.Buffer
+			if err := src.Snapshot(&buf); err != nil {
+				t.Fatalf("snapshot failed: %v", err)
+			}
+			dst, _ := NewLFUCache[string, int](5, WithClock(clk), WithCodec(codec))
+			dst.Put("old", 0)
+			if err := dst.Restore(&buf); err != nil {
+				t.Fatalf("restore failed: %v", err)
+			}
+
+			got := dst.TopK(10)
+			if len(got) != len(want) || len(got) != 4 {
+				t.Fatalf("expected %v restored but got %v", want, got)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("expected frequencies and order %v but got %v", want, got)
+					break
+				}
+			}
+			// c reached frequency 2 first, so it goes before b and d
+			dst.Put("e", 5)
+			dst.Put("f", 6)
+			if _, ok := dst.Peek("e"); ok {
+				t.Errorf("expected e, the only key at frequency 1, evicted by f")
+			}
+			dst.Put("g", 7)
+			dst.Get("g")
+			if _, ok := dst.Peek("c"); !ok {
+				t.Errorf("expected c kept while frequency 1 keys remain")
+			}
+			clk.Advance(time.Minute)
+			if _, ok := dst.Get("d"); ok {
+				t.Errorf("expected d's ttl to survive the restore")
+			}
+		})
+	}
+}
+
+func TestLFUCache_RestoreTrimsToCapacity(t *testing.T) {
+	src, _ := NewLFUCache[int, int](4)
+	for i := 0; i < 4; i++ {
+		src.Put(i, i)
+		for j := 0; j < i; j++ {
+			src.Get(i)
+		}
+	}
+	var buf bytes.Buffer
+	src.Snapshot(&buf)
+
+	dst, _ := NewLFUCache[int, int](2)
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if dst.Len() != 2 {
+		t.Fatalf("expected 2 entries but got %d", dst.Len())
+	}
+	for _, key := range []int{2, 3} {
+		if _, ok := dst.Peek(key); !ok {
+			t.Errorf("expected the most frequent key %d kept", key)
+		}
+	}
+
+	wrong, _ := NewLFUCache[int, int](2)
+	if err := wrong.Restore(bytes.NewReader([]byte("CSNP\x01\x03lru\x03gob"))); !errors.Is(err, snapshot.ErrFormat) {
+		t.Errorf("expected an lru snapshot to be rejected but got %v", err)
+	}
+	negative, _ := NewLFUCache[int, int](2, WithCodec(snapshot.JSON))
+	if err := negative.Restore(bytes.NewReader([]byte("CSNP\x01\x03lfu\x04json-1\n"))); !errors.Is(err, snapshot.ErrFormat) {
+		t.Errorf("expected a negative record count to be rejected but got %v", err)
+	}
+}
+
+func TestLFUCache_RestoreRejectsBadRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []lfuRecord[int, int]
+	}{
+		{"descending frequency", []lfuRecord[int, int]{{Key: 1, Freq: 3}, {Key: 2, Freq: 1}}},
+		{"duplicate key", []lfuRecord[int, int]{{Key: 1, Freq: 1}, {Key: 1, Freq: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			snapshot.WriteHeader(&buf, "lfu", snapshot.Gob)
+			snapshot.WriteRecords(&buf, snapshot.Gob, tt.records)
+
+			c, _ := NewLFUCache[int, int](4)
+			c.Put(9, 9)
+			if err := c.Restore(&buf); !errors.Is(err, snapshot.ErrFormat) {
+				t.Errorf("expected ErrFormat but got %v", err)
+			}
+			if _, ok := c.Peek(9); !ok || c.Len() != 1 {
+				t.Errorf("expected a rejected restore to leave the cache alone")
+			}
+		})
+	}
+}
+
+func TestLFUCache_OnEvict(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewLFUCache[string, int](2, WithClock(clk))
+	var evicted []string
+	c.OnEvict(func(key string, value int) { evicted = append(evicted, key) })
+
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a")
+	c.Put("c", 3) // evicts b, the least frequently used
+	c.Remove("a")
+	c.PutWithTTL("d", 4, time.Second)
+	clk.Advance(2 * time.Second)
+	c.Put("e", 5) // d expired, dropped without the callback
+
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("expected only b reported as evicted but got %v", evicted)
+	}
+}
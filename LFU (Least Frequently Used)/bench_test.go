@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// legacyLFU is the map-of-DLLs design LFUCache used before the frequency
+// list, kept to benchmark against. Every bump into an unseen frequency
+// allocates a list and two sentinels, and minFreq is found by scanning.
+type legacyLFU[K comparable, V any] struct {
+	capacity int
+	minFreq  int
+	cache    map[K]*Node[K, V]
+	freqMap  map[int]*DLL[K, V]
+}
+
+func newLegacyLFU[K comparable, V any](capacity int) *legacyLFU[K, V] {
+	return &legacyLFU[K, V]{
+		capacity: capacity,
+		cache:    make(map[K]*Node[K, V]),
+		freqMap:  make(map[int]*DLL[K, V]),
+	}
+}
+
+func (lfu *legacyLFU[K, V]) addNodeToFreqList(freq int, node *Node[K, V]) {
+	if dll, exists := lfu.freqMap[freq]; exists {
+		dll.addFront(node)
+	} else {
+		newList := NewDLL[K, V]()
+		newList.addFront(node)
+		lfu.freqMap[freq] = newList
+	}
+}
+
+func (lfu *legacyLFU[K, V]) removeNodeFromFreqList(node *Node[K, V]) {
+	if dll, exists := lfu.freqMap[node.freq]; exists {
+		dll.removeNode(node)
+		if dll.isEmpty() {
+			delete(lfu.freqMap, node.freq)
+		}
+	}
+}
+
+func (lfu *legacyLFU[K, V]) updateMinFreq() {
+	for {
+		if dll, exists := lfu.freqMap[lfu.minFreq]; !exists || dll.isEmpty() {
+			lfu.minFreq++
+		} else {
+			break
+		}
+	}
+}
+
+func (lfu *legacyLFU[K, V]) bump(node *Node[K, V]) {
+	oldFreq := node.freq
+	lfu.removeNodeFromFreqList(node)
+	node.freq++
+	lfu.addNodeToFreqList(node.freq, node)
+	if oldFreq == lfu.minFreq {
+		lfu.updateMinFreq()
+	}
+}
+
+func (lfu *legacyLFU[K, V]) Get(key K) (V, bool) {
+	if node, exists := lfu.cache[key]; exists {
+		lfu.bump(node)
+		return node.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (lfu *legacyLFU[K, V]) Put(key K, value V) {
+	if node, exists := lfu.cache[key]; exists {
+		node.value = value
+		lfu.bump(node)
+		return
+	}
+	if len(lfu.cache) >= lfu.capacity {
+		if dll, exists := lfu.freqMap[lfu.minFreq]; exists {
+			if tbRemoved := dll.removeLast(); tbRemoved != nil {
+				delete(lfu.cache, tbRemoved.key)
+			}
+			if dll.isEmpty() {
+				delete(lfu.freqMap, lfu.minFreq)
+			}
+		}
+	}
+	newNode := &Node[K, V]{key: key, value: value, freq: 1}
+	lfu.cache[key] = newNode
+	lfu.addNodeToFreqList(1, newNode)
+	lfu.minFreq = 1
+}
+
+type benchCache interface {
+	Get(key int) (int, bool)
+	Put(key, value int)
+}
+
+// skewed key stream, a few keys take most of the traffic like hot symbols
+func benchKeys(n, keySpace int) []int {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, uint64(keySpace-1))
+	keys := make([]int, n)
+	for i := range keys {
+		keys[i] = int(zipf.Uint64())
+	}
+	return keys
+}
+
+func benchmarkMixed(b *testing.B, c benchCache) {
+	keys := benchKeys(1<<16, 4096)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i&(len(keys)-1)]
+		if _, ok := c.Get(key); !ok {
+			c.Put(key, i)
+		}
+	}
+}
+
+// a single hot key climbing frequencies, every Get moves it to a new bucket
+func benchmarkHotKey(b *testing.B, c benchCache) {
+	for i := 0; i < 64; i++ {
+		c.Put(i, i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(0)
+	}
+}
+
+func BenchmarkLFU_Mixed(b *testing.B) {
+	c, _ := NewLFUCache[int, int](1024)
+	benchmarkMixed(b, c)
+}
+
+func BenchmarkLegacyLFU_Mixed(b *testing.B) {
+	benchmarkMixed(b, newLegacyLFU[int, int](1024))
+}
+
+func BenchmarkLFU_HotKey(b *testing.B) {
+	c, _ := NewLFUCache[int, int](1024)
+	benchmarkHotKey(b, c)
+}
+
+func BenchmarkLegacyLFU_HotKey(b *testing.B) {
+	benchmarkHotKey(b, newLegacyLFU[int, int](1024))
+}
@@ -0,0 +1,46 @@
+package main
+
+// Item is a snapshot of one cache entry with its access count.
+type Item[K comparable, V any] struct {
+	Key   K
+	Value V
+	Freq  int
+}
+
+// Frequency reports how often key has been read or written, without counting
+// as an access itself.
+func (lfu *LFUCache[K, V]) Frequency(key K) (int, bool) {
+	node, exists := lfu.cache[key]
+	if !exists || node.expired(lfu.clock.Now().UnixNano()) {
+		return 0, false
+	}
+	return node.freq, true
+}
+
+// TopK returns up to k live entries, most frequently used first and most
+// recently used first within a frequency. The frequency list is walked from
+// its highest bucket down until k entries are found.
+func (lfu *LFUCache[K, V]) TopK(k int) []Item[K, V] {
+	if k <= 0 {
+		return nil
+	}
+	now := lfu.clock.Now().UnixNano()
+	items := make([]Item[K, V], 0, min(k, len(lfu.cache)))
+	for fn := lfu.freqs.prev; fn != &lfu.freqs; fn = fn.prev {
+		for node := fn.items.head.next; node != fn.items.tail; node = node.next {
+			if node.expired(now) {
+				continue
+			}
+			items = append(items, Item[K, V]{Key: node.key, Value: node.value, Freq: node.freq})
+			if len(items) == k {
+				return items
+			}
+		}
+	}
+	return items
+}
+
+// Items returns every live entry in TopK order.
+func (lfu *LFUCache[K, V]) Items() []Item[K, V] {
+	return lfu.TopK(len(lfu.cache))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+// WithCodec picks how Snapshot encodes keys and values, gob by default.
+func WithCodec(codec snapshot.Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+type lfuRecord[K comparable, V any] struct {
+	Key      K
+	Value    V
+	Freq     int
+	ExpireAt int64
+	Seq      uint64
+}
+
+// Snapshot writes every live entry to w with its frequency and expiry time,
+// lowest frequency first and least recently used first within a frequency.
+func (lfu *LFUCache[K, V]) Snapshot(w io.Writer) error {
+	if err := snapshot.WriteHeader(w, "lfu", lfu.codec); err != nil {
+		return err
+	}
+	now := lfu.clock.Now().UnixNano()
+	var records []lfuRecord[K, V]
+	for fn := lfu.freqs.next; fn != &lfu.freqs; fn = fn.next {
+		for node := fn.items.tail.prev; node != fn.items.head; node = node.prev {
+			if node.expired(now) {
+				continue
+			}
+			records = append(records, lfuRecord[K, V]{
+				Key: node.key, Value: node.value, Freq: node.freq, ExpireAt: node.expireAt, Seq: node.seq,
+			})
+		}
+	}
+	return snapshot.WriteRecords(w, lfu.codec, records)
+}
+
+// Restore replaces the cache contents with a snapshot, keeping frequencies
+// and the order within each of them. Entries that expired since are skipped
+// and the least frequently used ones are dropped when the snapshot holds
+// more than the capacity. On error the cache is left as it was.
+func (lfu *LFUCache[K, V]) Restore(r io.Reader) error {
+	if err := snapshot.ReadHeader(r, "lfu", lfu.codec); err != nil {
+		return err
+	}
+	records, err := snapshot.ReadRecords[lfuRecord[K, V]](r, lfu.codec)
+	if err != nil {
+		return err
+	}
+	// buckets are rebuilt by appending, which needs ascending frequencies
+	seen := make(map[K]struct{}, len(records))
+	for i, rec := range records {
+		if _, dup := seen[rec.Key]; dup {
+			return fmt.Errorf("%w: key %v appears twice", snapshot.ErrFormat, rec.Key)
+		}
+		seen[rec.Key] = struct{}{}
+		if i > 0 && rec.Freq < records[i-1].Freq {
+			return fmt.Errorf("%w: frequencies out of order", snapshot.ErrFormat)
+		}
+	}
+
+	now := lfu.clock.Now().UnixNano()
+	live := records[:0]
+	for _, rec := range records {
+		if rec.ExpireAt == 0 || now <= rec.ExpireAt {
+			live = append(live, rec)
+		}
+	}
+	if len(live) > lfu.capacity {
+		live = live[len(live)-lfu.capacity:]
+	}
+
+	lfu.Clear()
+	lfu.seq = 0
+	for _, rec := range live {
+		freq := max(rec.Freq, 1)
+		if lfu.maxFreq > 0 {
+			freq = min(freq, lfu.maxFreq)
+		}
+		// records were checked to ascend in frequency, buckets only ever append
+		last := lfu.freqs.prev
+		if last == &lfu.freqs || last.freq != freq {
+			last = lfu.insertFreqNode(last, freq)
+		}
+		node := &Node[K, V]{key: rec.Key, value: rec.Value, freq: freq, expireAt: rec.ExpireAt, heapIdx: -1, seq: rec.Seq, parent: last}
+		last.items.addFront(node)
+		lfu.cache[rec.Key] = node
+		if rec.ExpireAt != 0 {
+			lfu.expiries.set(node)
+		}
+		lfu.seq = max(lfu.seq, rec.Seq)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// TieBreak picks the victim among the keys sharing the lowest frequency.
+type TieBreak int
+
+const (
+	TieLRU      TieBreak = iota // least recently used within the bucket, the default
+	TieFIFO                     // oldest key in the cache
+	TieRandom                   // any key of the bucket
+	TieSmallest                 // smallest value per the sizer, LRU among equal sizes
+)
+
+// WithTieBreak sets how the eviction victim is chosen among keys with the
+// lowest frequency. Anything but TieLRU scans that bucket.
+func WithTieBreak(tie TieBreak) Option {
+	return func(o *options) {
+		o.tie = tie
+	}
+}
+
+// WithMaxFrequency caps the counted frequency at max, so keys that were hot
+// once can still be overtaken and evicted. 0 leaves it unbounded.
+func WithMaxFrequency(max int) Option {
+	return func(o *options) {
+		o.maxFreq = max
+	}
+}
+
+// SetSizer tells TieSmallest how big a value is, without one it falls back to
+// LRU.
+func (lfu *LFUCache[K, V]) SetSizer(sizer func(V) int) {
+	lfu.sizer = sizer
+}
+
+// the node of the lowest bucket that the tie break policy evicts first
+func (lfu *LFUCache[K, V]) victim(lowest *freqNode[K, V]) *Node[K, V] {
+	items := lowest.items
+	lru := items.tail.prev
+	switch {
+	case lfu.tie == TieFIFO:
+		best := lru
+		for node := lru.prev; node != items.head; node = node.prev {
+			if node.seq < best.seq {
+				best = node
+			}
+		}
+		return best
+	case lfu.tie == TieRandom:
+		n := 0
+		for node := items.head.next; node != items.tail; node = node.next {
+			n++
+		}
+		node := items.head.next
+		for i := lfu.rnd.Intn(n); i > 0; i-- {
+			node = node.next
+		}
+		return node
+	case lfu.tie == TieSmallest && lfu.sizer != nil:
+		best, bestSize := lru, lfu.sizer(lru.value)
+		for node := lru.prev; node != items.head; node = node.prev {
+			if size := lfu.sizer(node.value); size < bestSize {
+				best, bestSize = node, size
+			}
+		}
+		return best
+	}
+	return lru
+}
+
+func newTieRand(tie TieBreak) *rand.Rand {
+	if tie != TieRandom {
+		return nil
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
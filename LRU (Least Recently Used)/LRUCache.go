@@ -2,13 +2,19 @@ package main
 
 import (
 	"errors"
+	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
 )
 
 type Node[K comparable, V any] struct {
-	key   K
-	value V
-	prev  *Node[K, V]
-	next  *Node[K, V]
+	key      K
+	value    V
+	expireAt int64 // unix nanos, 0 when the entry has no ttl
+	heapIdx  int
+	prev     *Node[K, V]
+	next     *Node[K, V]
 }
 
 type LRUCache[K comparable, V any] struct {
@@ -16,15 +22,40 @@ type LRUCache[K comparable, V any] struct {
 	cache    map[K]*Node[K, V]
 	head     *Node[K, V]
 	tail     *Node[K, V]
+	expiries expiryHeap[K, V]
+	clock    clock.Clock
+	codec    snapshot.Codec
+	onEvict  func(key K, value V)
+}
+
+type Option func(*options)
+
+type options struct {
+	clock clock.Clock
+	codec snapshot.Codec
 }
 
-func NewLRUCache[K comparable, V any](capacity int) (*LRUCache[K, V], error) {
+// WithClock swaps the wall clock used for ttl expiry, mostly for a clock.Fake
+// in tests.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func NewLRUCache[K comparable, V any](capacity int, opts ...Option) (*LRUCache[K, V], error) {
 	if capacity <= 0 {
 		return nil, errors.New("capacity must be positive")
 	}
+	o := options{clock: clock.Real, codec: snapshot.Gob}
+	for _, opt := range opts {
+		opt(&o)
+	}
 	cache := &LRUCache[K, V]{
 		capacity: capacity,
 		cache:    make(map[K]*Node[K, V]),
+		clock:    o.clock,
+		codec:    o.codec,
 	}
 	// Simplifying list operations by eliminating edge cases -
 	// - empty list or single node by Initializing with dummy head and tail nodes btw
@@ -63,23 +94,50 @@ func (c *LRUCache[K, V]) removeTail() *Node[K, V] {
 
 func (c *LRUCache[K, V]) Put(key K, value V) {
 	if node, exists := c.cache[key]; exists {
-		node.value = value
-		c.moveToHead(node)
-		return
+		// an expired entry already reads as a miss, the write starts it afresh
+		if !node.expired(c.clock.Now().UnixNano()) {
+			node.value = value
+			c.moveToHead(node)
+			return
+		}
+		c.removeEntry(node)
 	}
 
-	newNode := &Node[K, V]{key: key, value: value}
+	newNode := &Node[K, V]{key: key, value: value, heapIdx: -1}
 	c.cache[key] = newNode
 	c.addNode(newNode)
 
 	if len(c.cache) > c.capacity {
-		tail := c.removeTail()
-		delete(c.cache, tail.key)
+		// an expired entry goes before the least recently used live one
+		if expired := c.expiries.expired(c.clock.Now().UnixNano()); expired != nil {
+			c.removeEntry(expired)
+		} else {
+			tail := c.removeTail()
+			delete(c.cache, tail.key)
+			c.expiries.remove(tail)
+			if c.onEvict != nil {
+				c.onEvict(tail.key, tail.value)
+			}
+		}
 	}
 }
 
+// PutWithTTL stores the entry like Put and expires it after ttl. A later Put
+// of the same key keeps the ttl until it runs out.
+func (c *LRUCache[K, V]) PutWithTTL(key K, value V, ttl time.Duration) {
+	c.Put(key, value)
+	node := c.cache[key]
+	node.expireAt = c.clock.Now().Add(ttl).UnixNano()
+	c.expiries.set(node)
+}
+
 func (c *LRUCache[K, V]) Get(key K) (V, bool) {
 	if node, exists := c.cache[key]; exists {
+		if node.expired(c.clock.Now().UnixNano()) {
+			c.removeEntry(node)
+			var zero V
+			return zero, false
+		}
 		c.moveToHead(node)
 		return node.value, true
 	}
@@ -87,15 +145,42 @@ func (c *LRUCache[K, V]) Get(key K) (V, bool) {
 	return zero, false
 }
 
+// OnEvict registers fn to be called with every live entry pushed out to make
+// room. Expired entries and explicit removals don't count.
+func (c *LRUCache[K, V]) OnEvict(fn func(key K, value V)) {
+	c.onEvict = fn
+}
+
 func (c *LRUCache[K, V]) Remove(key K) bool {
 	if node, exists := c.cache[key]; exists {
-		c.removeNode(node)
-		delete(c.cache, key)
+		c.removeEntry(node)
 		return true
 	}
 	return false
 }
 
+// DeleteExpired proactively drops every expired entry and returns how many
+// went, without it they are only dropped when read or evicted.
+func (c *LRUCache[K, V]) DeleteExpired() int {
+	now := c.clock.Now().UnixNano()
+	n := 0
+	for node := c.expiries.expired(now); node != nil; node = c.expiries.expired(now) {
+		c.removeEntry(node)
+		n++
+	}
+	return n
+}
+
+func (c *LRUCache[K, V]) removeEntry(node *Node[K, V]) {
+	c.removeNode(node)
+	delete(c.cache, node.key)
+	c.expiries.remove(node)
+}
+
+func (n *Node[K, V]) expired(now int64) bool {
+	return n.expireAt != 0 && now > n.expireAt
+}
+
 func (c *LRUCache[K, V]) Len() int {
 	return len(c.cache)
 }
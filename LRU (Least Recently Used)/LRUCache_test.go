@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
+)
 
 func TestLRUCache_BasicOps(t *testing.T) {
 	tests := []struct {
@@ -81,3 +89,200 @@ func TestLRUCache_Eviction(t *testing.T) {
 		})
 	}
 }
+
+func TestLRUCache_TTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(c *LRUCache[int, string], clk *clock.Fake)
+		wantEvict []int
+		wantKeep  []int
+	}{
+		{
+			name: "lazy expiry on get",
+			setup: func(c *LRUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				c.Put(2, "b")
+				clk.Advance(2 * time.Second)
+			},
+			wantEvict: []int{1},
+			wantKeep:  []int{2},
+		},
+		{
+			name: "expired evicted before lru",
+			setup: func(c *LRUCache[int, string], clk *clock.Fake) {
+				c.Put(1, "a")
+				c.PutWithTTL(2, "b", time.Second)
+				c.Put(3, "c")
+				clk.Advance(2 * time.Second)
+				c.Put(4, "d")
+			},
+			wantEvict: []int{2},
+			wantKeep:  []int{1, 3, 4},
+		},
+		{
+			name: "live ttl entry follows lru",
+			setup: func(c *LRUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Hour)
+				c.Put(2, "b")
+				c.Put(3, "c")
+				c.Put(4, "d")
+			},
+			wantEvict: []int{1},
+			wantKeep:  []int{2, 3, 4},
+		},
+		{
+			name: "put after expiry starts afresh",
+			setup: func(c *LRUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				clk.Advance(2 * time.Second)
+				c.Put(1, "b")
+				clk.Advance(time.Hour)
+			},
+			wantKeep: []int{1},
+		},
+		{
+			name: "put keeps a live ttl",
+			setup: func(c *LRUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				c.Put(1, "b")
+				clk.Advance(2 * time.Second)
+			},
+			wantEvict: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			c, _ := NewLRUCache[int, string](3, WithClock(clk))
+			tt.setup(c, clk)
+			for _, k := range tt.wantEvict {
+				if _, ok := c.Get(k); ok {
+					t.Errorf("expected %d to be gone but still present", k)
+				}
+			}
+			for _, k := range tt.wantKeep {
+				if _, ok := c.Get(k); !ok {
+					t.Errorf("expected %d to be present", k)
+				}
+			}
+		})
+	}
+}
+
+func TestLRUCache_DeleteExpired(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewLRUCache[int, string](4, WithClock(clk))
+	c.PutWithTTL(1, "a", time.Second)
+	c.PutWithTTL(2, "b", 3*time.Second)
+	c.PutWithTTL(3, "c", time.Second)
+	c.Put(4, "d")
+
+	clk.Advance(2 * time.Second)
+	if n := c.DeleteExpired(); n != 2 {
+		t.Errorf("expected 2 expired entries dropped but got %d", n)
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries left but got %d", c.Len())
+	}
+	c.Remove(2)
+	clk.Advance(time.Hour)
+	if n := c.DeleteExpired(); n != 0 || c.Len() != 1 {
+		t.Errorf("expected removed key to leave the expiry heap, got %d dropped and len %d", n, c.Len())
+	}
+}
+
+func TestLRUCache_Snapshot(t *testing.T) {
+	for _, codec := range []snapshot.Codec{snapshot.Gob, snapshot.JSON} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			src, _ := NewLRUCache[string, int](4, WithClock(clk), WithCodec(codec))
+			src.Put("a", 1)
+			src.PutWithTTL("b", 2, time.Minute)
+			src.PutWithTTL("gone", 0, time.Second)
+			src.Put("c", 3)
+			src.Get("a")
+			clk.Advance(2 * time.Second)
+
+			var buf bytes.Buffer
+			if err := src.Snapshot(&buf); err != nil {
+				t.Fatalf("snapshot failed: %v", err)
+			}
+			dst, _ := NewLRUCache[string, int](3, WithClock(clk), WithCodec(codec))
+			dst.Put("stale", 9)
+			if err := dst.Restore(&buf); err != nil {
+				t.Fatalf("restore failed: %v", err)
+			}
+
+			if dst.Len() != 3 {
+				t.Fatalf("expected a, b and c restored but got %d entries", dst.Len())
+			}
+			if _, ok := dst.Get("stale"); ok {
+				t.Errorf("expected restore to replace the old contents")
+			}
+			// b is now the least recently used, then c
+			dst.Put("d", 4)
+			if _, ok := dst.cache["b"]; ok {
+				t.Errorf("expected recency order restored, b should have been evicted")
+			}
+			clk.Advance(time.Minute)
+			if v, ok := dst.Get("c"); !ok || v != 3 {
+				t.Errorf("expected c without ttl to survive but got %v %v", v, ok)
+			}
+		})
+	}
+}
+
+func TestLRUCache_RestoreRejects(t *testing.T) {
+	src, _ := NewLRUCache[string, int](2)
+	src.Put("a", 1)
+	var gobSnap bytes.Buffer
+	src.Snapshot(&gobSnap)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		codec snapshot.Codec
+		want  error
+	}{
+		{"garbage", []byte("hello world"), snapshot.Gob, snapshot.ErrFormat},
+		{"empty", nil, snapshot.Gob, snapshot.ErrFormat},
+		{"other codec", gobSnap.Bytes(), snapshot.JSON, snapshot.ErrFormat},
+		{"newer version", append([]byte("CSNP\x02"), gobSnap.Bytes()[5:]...), snapshot.Gob, snapshot.ErrVersion},
+		{"other policy", []byte("CSNP\x01\x03lfu\x03gob"), snapshot.Gob, snapshot.ErrFormat},
+		{"negative count", []byte("CSNP\x01\x03lru\x04json-1\n"), snapshot.JSON, snapshot.ErrFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, _ := NewLRUCache[string, int](2, WithCodec(tt.codec))
+			dst.Put("keep", 1)
+			if err := dst.Restore(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, err)
+			}
+			if _, ok := dst.Get("keep"); !ok {
+				t.Errorf("expected a failed restore to leave the cache alone")
+			}
+		})
+	}
+}
+
+func TestLRUCache_OnEvict(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewLRUCache[string, int](2, WithClock(clk))
+	var evicted []string
+	c.OnEvict(func(key string, value int) { evicted = append(evicted, key) })
+
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a")
+	c.Put("c", 3) // evicts b
+	c.Remove("a")
+	c.PutWithTTL("d", 4, time.Second)
+	clk.Advance(2 * time.Second)
+	c.Put("e", 5) // d expired, dropped without the callback
+	c.Put("f", 6) // evicts c
+
+	if len(evicted) != 2 || evicted[0] != "b" || evicted[1] != "c" {
+		t.Errorf("expected b and c reported as evicted but got %v", evicted)
+	}
+}
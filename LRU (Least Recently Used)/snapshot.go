@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+// WithCodec picks how Snapshot encodes keys and values, gob by default.
+func WithCodec(codec snapshot.Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+type lruRecord[K comparable, V any] struct {
+	Key      K
+	Value    V
+	ExpireAt int64
+}
+
+// Snapshot writes every live entry to w, least recently used first, along
+// with its expiry time.
+func (c *LRUCache[K, V]) Snapshot(w io.Writer) error {
+	if err := snapshot.WriteHeader(w, "lru", c.codec); err != nil {
+		return err
+	}
+	now := c.clock.Now().UnixNano()
+	var records []lruRecord[K, V]
+	for node := c.tail.prev; node != c.head; node = node.prev {
+		if !node.expired(now) {
+			records = append(records, lruRecord[K, V]{Key: node.key, Value: node.value, ExpireAt: node.expireAt})
+		}
+	}
+	return snapshot.WriteRecords(w, c.codec, records)
+}
+
+// Restore replaces the cache contents with a snapshot, keeping its recency
+// order. Entries that expired since are skipped and the most recent ones
+// win when the snapshot holds more than the capacity. On error the cache is
+// left as it was.
+func (c *LRUCache[K, V]) Restore(r io.Reader) error {
+	if err := snapshot.ReadHeader(r, "lru", c.codec); err != nil {
+		return err
+	}
+	records, err := snapshot.ReadRecords[lruRecord[K, V]](r, c.codec)
+	if err != nil {
+		return err
+	}
+
+	c.reset()
+	now := c.clock.Now().UnixNano()
+	for _, rec := range records {
+		if rec.ExpireAt != 0 && now > rec.ExpireAt {
+			continue
+		}
+		c.Put(rec.Key, rec.Value)
+		if rec.ExpireAt != 0 {
+			node := c.cache[rec.Key]
+			node.expireAt = rec.ExpireAt
+			c.expiries.set(node)
+		}
+	}
+	return nil
+}
+
+func (c *LRUCache[K, V]) reset() {
+	c.cache = make(map[K]*Node[K, V])
+	c.head.next = c.tail
+	c.tail.prev = c.head
+	c.expiries = nil
+}
@@ -1,10 +1,18 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
+)
 
 type Node[K comparable, V any] struct {
 	key        K
 	value      V
+	expireAt   int64 // unix nanos, 0 when the entry has no ttl
+	heapIdx    int
 	prev, next *Node[K, V]
 }
 
@@ -12,15 +20,40 @@ type MRUCache[K comparable, V any] struct {
 	capacity   int
 	cache      map[K]*Node[K, V]
 	head, tail *Node[K, V]
+	expiries   expiryHeap[K, V]
+	clock      clock.Clock
+	codec      snapshot.Codec
+	onEvict    func(key K, value V)
+}
+
+type Option func(*options)
+
+type options struct {
+	clock clock.Clock
+	codec snapshot.Codec
 }
 
-func NewMRUCache[K comparable, V any](capacity int) (*MRUCache[K, V], error) {
+// WithClock swaps the wall clock used for ttl expiry, mostly for a clock.Fake
+// in tests.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func NewMRUCache[K comparable, V any](capacity int, opts ...Option) (*MRUCache[K, V], error) {
 	if capacity <= 0 {
 		return nil, errors.New("capacity must be positive")
 	}
+	o := options{clock: clock.Real, codec: snapshot.Gob}
+	for _, opt := range opts {
+		opt(&o)
+	}
 	cache := &MRUCache[K, V]{
 		capacity: capacity,
 		cache:    make(map[K]*Node[K, V]),
+		clock:    o.clock,
+		codec:    o.codec,
 	}
 	cache.head = &Node[K, V]{}
 	cache.tail = &Node[K, V]{}
@@ -47,40 +80,93 @@ func (c *MRUCache[K, V]) movetoHead(node *Node[K, V]) {
 
 func (c *MRUCache[K, V]) Put(key K, value V) {
 	if node, exists := c.cache[key]; exists {
-		node.value = value
-		c.movetoHead(node)
-		return
+		// an expired entry already reads as a miss, the write starts it afresh
+		if !node.expired(c.clock.Now().UnixNano()) {
+			node.value = value
+			c.movetoHead(node)
+			return
+		}
+		c.removeEntry(node)
 	}
 	var tbRemoved *Node[K, V]
+	evicted := false
 	if len(c.cache) >= c.capacity {
-		tbRemoved = c.head.next
+		// an expired entry goes before the most recently used live one
+		if tbRemoved = c.expiries.expired(c.clock.Now().UnixNano()); tbRemoved == nil {
+			tbRemoved = c.head.next
+			evicted = true
+		}
 	}
-	newNode := &Node[K, V]{key: key, value: value}
+	newNode := &Node[K, V]{key: key, value: value, heapIdx: -1}
 	c.cache[key] = newNode
 	c.addNode(newNode)
 
 	if tbRemoved != nil {
-		c.removeNode(tbRemoved)
-		delete(c.cache, tbRemoved.key)
+		c.removeEntry(tbRemoved)
+		if evicted && c.onEvict != nil {
+			c.onEvict(tbRemoved.key, tbRemoved.value)
+		}
 	}
 }
 
+// PutWithTTL stores the entry like Put and expires it after ttl. A later Put
+// of the same key keeps the ttl until it runs out.
+func (c *MRUCache[K, V]) PutWithTTL(key K, value V, ttl time.Duration) {
+	c.Put(key, value)
+	node := c.cache[key]
+	node.expireAt = c.clock.Now().Add(ttl).UnixNano()
+	c.expiries.set(node)
+}
+
 func (c *MRUCache[K, V]) Get(key K) (V, bool) {
 	if node, exists := c.cache[key]; exists {
+		if node.expired(c.clock.Now().UnixNano()) {
+			c.removeEntry(node)
+			var zero V
+			return zero, false
+		}
 		c.movetoHead(node)
 		return node.value, true
 	}
 	var zero V
 	return zero, false
 }
+
+// OnEvict registers fn to be called with every live entry pushed out to make
+// room. Expired entries and explicit removals don't count.
+func (c *MRUCache[K, V]) OnEvict(fn func(key K, value V)) {
+	c.onEvict = fn
+}
+
 func (c *MRUCache[K, V]) Remove(key K) bool {
 	if node, exists := c.cache[key]; exists {
-		c.removeNode(node)
-		delete(c.cache, key)
+		c.removeEntry(node)
 		return true
 	}
 	return false
 }
+
+// DeleteExpired proactively drops every expired entry and returns how many
+// went, without it they are only dropped when read or evicted.
+func (c *MRUCache[K, V]) DeleteExpired() int {
+	now := c.clock.Now().UnixNano()
+	n := 0
+	for node := c.expiries.expired(now); node != nil; node = c.expiries.expired(now) {
+		c.removeEntry(node)
+		n++
+	}
+	return n
+}
+
+func (c *MRUCache[K, V]) removeEntry(node *Node[K, V]) {
+	c.removeNode(node)
+	delete(c.cache, node.key)
+	c.expiries.remove(node)
+}
+
+func (n *Node[K, V]) expired(now int64) bool {
+	return n.expireAt != 0 && now > n.expireAt
+}
 func (c *MRUCache[K, V]) Len() int {
 	return len(c.cache)
 }
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"testing"
+	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
 )
 
 func TestMruCache_BasicOps(t *testing.T) {
@@ -68,3 +74,171 @@ func TestMRUCache_Eviction(t *testing.T) {
 		})
 	}
 }
+
+func TestMRUCache_TTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(c *MRUCache[int, string], clk *clock.Fake)
+		wantEvict []int
+		wantKeep  []int
+	}{
+		{
+			name: "lazy expiry on get",
+			setup: func(c *MRUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				c.Put(2, "b")
+				clk.Advance(2 * time.Second)
+			},
+			wantEvict: []int{1},
+			wantKeep:  []int{2},
+		},
+		{
+			name: "expired evicted before mru",
+			setup: func(c *MRUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				c.Put(2, "b")
+				c.Put(3, "c")
+				clk.Advance(2 * time.Second)
+				c.Put(4, "d")
+			},
+			wantEvict: []int{1},
+			wantKeep:  []int{2, 3, 4},
+		},
+		{
+			name: "live ttl entry follows mru",
+			setup: func(c *MRUCache[int, string], clk *clock.Fake) {
+				c.Put(1, "a")
+				c.Put(2, "b")
+				c.PutWithTTL(3, "c", time.Hour)
+				c.Put(4, "d")
+			},
+			wantEvict: []int{3},
+			wantKeep:  []int{1, 2, 4},
+		},
+		{
+			name: "put after expiry starts afresh",
+			setup: func(c *MRUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				clk.Advance(2 * time.Second)
+				c.Put(1, "b")
+				clk.Advance(time.Hour)
+			},
+			wantKeep: []int{1},
+		},
+		{
+			name: "put keeps a live ttl",
+			setup: func(c *MRUCache[int, string], clk *clock.Fake) {
+				c.PutWithTTL(1, "a", time.Second)
+				c.Put(1, "b")
+				clk.Advance(2 * time.Second)
+			},
+			wantEvict: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			c, _ := NewMRUCache[int, string](3, WithClock(clk))
+			tt.setup(c, clk)
+			for _, k := range tt.wantEvict {
+				if _, ok := c.Get(k); ok {
+					t.Errorf("expected %d to be gone but still present", k)
+				}
+			}
+			for _, k := range tt.wantKeep {
+				if _, ok := c.Get(k); !ok {
+					t.Errorf("expected %d to be present", k)
+				}
+			}
+		})
+	}
+}
+
+func TestMRUCache_DeleteExpired(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewMRUCache[int, string](4, WithClock(clk))
+	c.PutWithTTL(1, "a", time.Second)
+	c.PutWithTTL(2, "b", 3*time.Second)
+	c.Put(3, "c")
+
+	clk.Advance(2 * time.Second)
+	if n := c.DeleteExpired(); n != 1 || c.Len() != 2 {
+		t.Errorf("expected 1 expired entry dropped but got %d and len %d", n, c.Len())
+	}
+}
+
+func TestMRUCache_Snapshot(t *testing.T) {
+	for _, codec := range []snapshot.Codec{snapshot.Gob, snapshot.JSON} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			src, _ := NewMRUCache[string, int](4, WithClock(clk), WithCodec(codec))
+			src.Put("a", 1)
+			src.PutWithTTL("b", 2, time.Minute)
+			src.PutWithTTL("gone", 0, time.Second)
+			src.Put("c", 3)
+			src.Get("a")
+			clk.Advance(2 * time.Second)
+
+			var buf bytes.Buffer
+			if err := src.Snapshot(&buf); err != nil {
+				t.Fatalf("snapshot failed: %v", err)
+			}
+			dst, _ := NewMRUCache[string, int](3, WithClock(clk), WithCodec(codec))
+			if err := dst.Restore(&buf); err != nil {
+				t.Fatalf("restore failed: %v", err)
+			}
+			if dst.Len() != 3 {
+				t.Fatalf("expected a, b and c restored but got %d entries", dst.Len())
+			}
+			// a was read last, so it is the one evicted
+			dst.Put("d", 4)
+			if _, ok := dst.cache["a"]; ok {
+				t.Errorf("expected recency order restored, a should have been evicted")
+			}
+			clk.Advance(time.Minute)
+			if _, ok := dst.Get("b"); ok {
+				t.Errorf("expected b's ttl to survive the restore")
+			}
+		})
+	}
+}
+
+func TestMRUCache_RestoreRejects(t *testing.T) {
+	src, _ := NewMRUCache[string, int](2)
+	src.Put("a", 1)
+	var snap bytes.Buffer
+	src.Snapshot(&snap)
+
+	lru := []byte("CSNP\x01\x03lru\x03gob")
+	for _, data := range [][]byte{nil, []byte("nope"), lru} {
+		dst, _ := NewMRUCache[string, int](2)
+		if err := dst.Restore(bytes.NewReader(data)); !errors.Is(err, snapshot.ErrFormat) {
+			t.Errorf("expected snapshot.ErrFormat for %q but got %v", data, err)
+		}
+	}
+	dst, _ := NewMRUCache[string, int](2, WithCodec(snapshot.JSON))
+	if err := dst.Restore(&snap); !errors.Is(err, snapshot.ErrFormat) {
+		t.Errorf("expected a codec mismatch to be rejected but got %v", err)
+	}
+}
+
+func TestMRUCache_OnEvict(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewMRUCache[string, int](2, WithClock(clk))
+	var evicted []string
+	c.OnEvict(func(key string, value int) { evicted = append(evicted, key) })
+
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a")
+	c.Put("c", 3) // evicts a, the most recently used
+	c.Remove("b")
+	c.PutWithTTL("d", 4, time.Second)
+	clk.Advance(2 * time.Second)
+	c.Put("e", 5) // d expired, dropped without the callback
+
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Errorf("expected only a reported as evicted but got %v", evicted)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "container/heap"
+
+// min-heap of the entries that carry a ttl, soonest expiry at the root, so
+// expired entries can be found without scanning the whole cache
+type expiryHeap[K comparable, V any] []*Node[K, V]
+
+func (h expiryHeap[K, V]) Len() int { return len(h) }
+
+func (h expiryHeap[K, V]) Less(i, j int) bool { return h[i].expireAt < h[j].expireAt }
+
+func (h expiryHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIdx = i
+	h[j].heapIdx = j
+}
+
+func (h *expiryHeap[K, V]) Push(x any) {
+	node := x.(*Node[K, V])
+	node.heapIdx = len(*h)
+	*h = append(*h, node)
+}
+
+func (h *expiryHeap[K, V]) Pop() any {
+	old := *h
+	last := len(old) - 1
+	node := old[last]
+	old[last] = nil
+	*h = old[:last]
+	node.heapIdx = -1
+	return node
+}
+
+// adds the node or moves it after its expiry changed
+func (h *expiryHeap[K, V]) set(node *Node[K, V]) {
+	if node.heapIdx >= 0 {
+		heap.Fix(h, node.heapIdx)
+	} else {
+		heap.Push(h, node)
+	}
+}
+
+func (h *expiryHeap[K, V]) remove(node *Node[K, V]) {
+	if node.heapIdx >= 0 {
+		heap.Remove(h, node.heapIdx)
+	}
+}
+
+// the soonest expiring node if it is already past now
+func (h expiryHeap[K, V]) expired(now int64) *Node[K, V] {
+	if len(h) > 0 && h[0].expireAt < now {
+		return h[0]
+	}
+	return nil
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cacheEvicitonPolicies/internal/atomicfile"
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/frame"
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+// Cache is what the log needs from the cache it protects. LRUCache, LFUCache
+// and MRUCache fit as they are, RandomCache and TTLCache need a small adapter
+// mapping their Set/Delete names.
+type Cache[K comparable, V any] interface {
+	Put(key K, value V)
+	PutWithTTL(key K, value V, ttl time.Duration)
+	Remove(key K) bool
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+// Op is the kind of change a log record holds.
+type Op uint8
+
+const (
+	OpPut Op = iota + 1
+	OpRemove
+	OpExpire
+)
+
+// SyncPolicy decides when appended records are forced to disk.
+type SyncPolicy int
+
+const (
+	SyncEverySecond SyncPolicy = iota // at most a second of records lost on power failure
+	SyncAlways                        // fsync after every record
+	SyncNever                         // left to the OS, survives a process crash only
+)
+
+type record[K comparable, V any] struct {
+	Op       Op
+	Key      K
+	Value    V
+	ExpireAt int64 // unix nanos, 0 when the entry has no ttl
+}
+
+type Option func(*options)
+
+type options struct {
+	codec     snapshot.Codec
+	sync      SyncPolicy
+	compactAt int64
+	clock     clock.Clock
+}
+
+// WithCodec picks how records and snapshots are encoded, gob by default.
+func WithCodec(codec snapshot.Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithClock swaps the wall clock that ttls are turned into expiry times
+// against, mostly for a clock.Fake in tests.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithSync sets when the log is fsynced, every second by default.
+func WithSync(policy SyncPolicy) Option {
+	return func(o *options) {
+		o.sync = policy
+	}
+}
+
+// WithCompactAt compacts the log into a new snapshot once it grows past
+// size bytes. 0 leaves compaction to explicit Compact calls.
+func WithCompactAt(size int64) Option {
+	return func(o *options) {
+		o.compactAt = size
+	}
+}
+
+// Log makes a cache crash consistent. Every change goes through the log,
+// which appends it to the current log file and only then applies it to the
+// cache. Open restores the latest snapshot and replays the log written after
+// it.
+//
+// Files come in generations: snapshot.N holds the cache as it was when
+// oplog.N was started, so compaction writes snapshot.N+1 and switches to an
+// empty oplog.N+1 before the older pair is deleted.
+type Log[K comparable, V any] struct {
+	mu        sync.Mutex
+	dir       string
+	cache     Cache[K, V]
+	codec     snapshot.Codec
+	sync      SyncPolicy
+	compactAt int64
+	clock     clock.Clock
+	gen       int
+	file      *os.File
+	size      int64
+	dirty     bool // written since the last fsync
+	done      chan struct{}
+	closed    bool
+	broken    error // set when a torn record couldn't be cut off, refuses writes
+
+	onCompactErr func(err error)
+}
+
+func Open[K comparable, V any](dir string, cache Cache[K, V], opts ...Option) (*Log[K, V], error) {
+	o := options{codec: snapshot.Gob, clock: clock.Real}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &Log[K, V]{
+		dir:       dir,
+		cache:     cache,
+		codec:     o.codec,
+		sync:      o.sync,
+		compactAt: o.compactAt,
+		clock:     o.clock,
+		done:      make(chan struct{}),
+	}
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+	if l.sync == SyncEverySecond {
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+// restores the newest snapshot, replays its log and reopens it for appends
+func (l *Log[K, V]) recover() error {
+	gens, err := l.generations()
+	if err != nil {
+		return err
+	}
+	if len(gens) > 0 {
+		l.gen = gens[len(gens)-1]
+	}
+	if f, err := os.Open(l.path("snapshot")); err == nil {
+		err = l.cache.Restore(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("restore %s: %w", l.path("snapshot"), err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path("oplog"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	good, err := l.replay(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// a torn record at the end is what a crash mid-append leaves, it never
+	// made it so it is cut off
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	if good == 0 {
+		if good, err = l.writeHeader(f); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	l.file, l.size = f, good
+	l.removeOlder()
+	return nil
+}
+
+// applies every intact record and returns the offset right after the last one
+func (l *Log[K, V]) replay(f *os.File) (int64, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var want bytes.Buffer
+	snapshot.WriteHeader(&want, "oplog", l.codec)
+	if len(data) < want.Len() && bytes.HasPrefix(want.Bytes(), data) {
+		// crashed while the header of a fresh log was written
+		return 0, nil
+	}
+	r := bytes.NewReader(data)
+	if err := snapshot.ReadHeader(r, "oplog", l.codec); err != nil {
+		return 0, fmt.Errorf("%s: %w", l.path("oplog"), err)
+	}
+	good := int64(len(data) - r.Len())
+	now := l.clock.Now().UnixNano()
+	for {
+		// a torn or damaged frame ends the replay, everything after it is cut
+		payload, n, err := frame.Next(data[good:])
+		if err != nil {
+			return good, nil
+		}
+		var rec record[K, V]
+		if err := l.codec.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+			return good, nil
+		}
+		l.apply(rec, now)
+		good += int64(n)
+	}
+}
+
+func (l *Log[K, V]) apply(rec record[K, V], now int64) {
+	switch rec.Op {
+	case OpPut:
+		switch {
+		case rec.ExpireAt == 0:
+			l.cache.Put(rec.Key, rec.Value)
+		case rec.ExpireAt > now:
+			l.cache.PutWithTTL(rec.Key, rec.Value, time.Duration(rec.ExpireAt-now))
+		default:
+			// expired while the process was down
+			l.cache.Remove(rec.Key)
+		}
+	case OpRemove, OpExpire:
+		l.cache.Remove(rec.Key)
+	}
+}
+
+// Put stores the entry in the cache and logs it, ttl 0 means no expiry.
+func (l *Log[K, V]) Put(key K, value V, ttl time.Duration) error {
+	rec := record[K, V]{Op: OpPut, Key: key, Value: value}
+	if ttl > 0 {
+		rec.ExpireAt = l.clock.Now().Add(ttl).UnixNano()
+	}
+	return l.do(rec)
+}
+
+// Remove deletes key from the cache and logs it.
+func (l *Log[K, V]) Remove(key K) error {
+	return l.do(record[K, V]{Op: OpRemove, Key: key})
+}
+
+// Expire records that key expired, meant to be called from an expiry
+// callback. The key is removed from the cache if it is still there.
+func (l *Log[K, V]) Expire(key K) error {
+	return l.do(record[K, V]{Op: OpExpire, Key: key})
+}
+
+func (l *Log[K, V]) do(rec record[K, V]) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return os.ErrClosed
+	}
+	if l.broken != nil {
+		l.mu.Unlock()
+		return l.broken
+	}
+	// logged before it is applied, a change the log couldn't record must not
+	// reach the cache
+	if err := l.append(rec); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	l.apply(rec, l.clock.Now().UnixNano())
+	if l.sync == SyncAlways {
+		if err := l.fsync(); err != nil {
+			l.mu.Unlock()
+			return err
+		}
+	}
+	// the write is logged either way, a failed compaction is no reason to
+	// retry it and is attempted again with the next one
+	var compactErr error
+	if l.compactAt > 0 && l.size >= l.compactAt {
+		compactErr = l.compact()
+	}
+	onErr := l.onCompactErr
+	l.mu.Unlock()
+	if compactErr != nil && onErr != nil {
+		onErr(compactErr)
+	}
+	return nil
+}
+
+func (l *Log[K, V]) append(rec record[K, V]) error {
+	var payload bytes.Buffer
+	if err := l.codec.NewEncoder(&payload).Encode(rec); err != nil {
+		return err
+	}
+	buf := frame.Append(make([]byte, 0, frame.HeaderSize+payload.Len()), payload.Bytes())
+
+	// one write per record, so a crash can only tear the last one
+	if _, err := l.file.Write(buf); err != nil {
+		// a torn frame left mid-file would end every later replay there
+		if cutErr := l.cut(); cutErr != nil {
+			l.broken = fmt.Errorf("oplog unusable, torn record left in place: %w", errors.Join(err, cutErr))
+			return l.broken
+		}
+		return err
+	}
+	l.size += int64(len(buf))
+	l.dirty = true
+	return nil
+}
+
+// drops whatever a failed write left past the last whole record
+func (l *Log[K, V]) cut() error {
+	if err := l.file.Truncate(l.size); err != nil {
+		return err
+	}
+	_, err := l.file.Seek(l.size, io.SeekStart)
+	return err
+}
+
+func (l *Log[K, V]) writeHeader(f *os.File) (int64, error) {
+	var head bytes.Buffer
+	if err := snapshot.WriteHeader(&head, "oplog", l.codec); err != nil {
+		return 0, err
+	}
+	n, err := f.Write(head.Bytes())
+	return int64(n), err
+}
+
+// OnCompactError registers fn to hear about automatic compactions that
+// failed, the writes that triggered them still succeed. It is called without
+// the log lock held.
+func (l *Log[K, V]) OnCompactError(fn func(err error)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onCompactErr = fn
+}
+
+// Compact snapshots the cache into the next generation and starts an empty
+// log for it.
+func (l *Log[K, V]) Compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return os.ErrClosed
+	}
+	return l.compact()
+}
+
+func (l *Log[K, V]) compact() error {
+	next := l.gen + 1
+	snap := l.pathFor("snapshot", next)
+	if err := atomicfile.Write(snap, l.cache.Snapshot); err != nil {
+		return err
+	}
+	f, size, err := l.startLog(next)
+	if err != nil {
+		// without its log the new snapshot must not win at recovery, records
+		// keep going to the current generation until a later attempt works
+		os.Remove(snap)
+		return err
+	}
+	l.file.Close()
+	l.file, l.size, l.gen, l.dirty = f, size, next, false
+	l.removeOlder()
+	return nil
+}
+
+// creates the empty log of generation gen, header written and synced
+func (l *Log[K, V]) startLog(gen int) (*os.File, int64, error) {
+	path := l.pathFor("oplog", gen)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := l.writeHeader(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+// Sync forces the records written so far to disk.
+func (l *Log[K, V]) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return os.ErrClosed
+	}
+	return l.fsync()
+}
+
+func (l *Log[K, V]) fsync() error {
+	if !l.dirty && l.sync != SyncAlways {
+		return nil
+	}
+	l.dirty = false
+	return l.file.Sync()
+}
+
+func (l *Log[K, V]) syncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if !l.closed {
+				l.fsync()
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Close syncs and closes the log, the cache itself stays usable.
+func (l *Log[K, V]) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	err := l.file.Sync()
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (l *Log[K, V]) path(kind string) string {
+	return l.pathFor(kind, l.gen)
+}
+
+func (l *Log[K, V]) pathFor(kind string, gen int) string {
+	return filepath.Join(l.dir, kind+"."+strconv.Itoa(gen))
+}
+
+// generations with a snapshot or log file on disk, oldest first
+func (l *Log[K, V]) generations() ([]int, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int]bool{}
+	for _, e := range entries {
+		kind, num, ok := strings.Cut(e.Name(), ".")
+		if !ok || (kind != "snapshot" && kind != "oplog") {
+			continue
+		}
+		if gen, err := strconv.Atoi(num); err == nil {
+			seen[gen] = true
+		}
+	}
+	gens := make([]int, 0, len(seen))
+	for gen := range seen {
+		gens = append(gens, gen)
+	}
+	sort.Ints(gens)
+	return gens, nil
+}
+
+// deletes the files of generations before the current one, best effort
+func (l *Log[K, V]) removeOlder() {
+	gens, _ := l.generations()
+	for _, gen := range gens {
+		if gen < l.gen {
+			os.Remove(l.pathFor("snapshot", gen))
+			os.Remove(l.pathFor("oplog", gen))
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+// map backed stand-in for the caches in this repo, which live in their own
+// packages
+type mapCache struct {
+	data    map[string]int
+	expires map[string]time.Time
+	snapErr error // makes Snapshot fail
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{data: map[string]int{}, expires: map[string]time.Time{}}
+}
+
+func (c *mapCache) Put(key string, value int) {
+	c.data[key] = value
+	delete(c.expires, key)
+}
+
+func (c *mapCache) PutWithTTL(key string, value int, ttl time.Duration) {
+	c.data[key] = value
+	c.expires[key] = time.Now().Add(ttl)
+}
+
+func (c *mapCache) Remove(key string) bool {
+	_, ok := c.data[key]
+	delete(c.data, key)
+	delete(c.expires, key)
+	return ok
+}
+
+func (c *mapCache) Snapshot(w io.Writer) error {
+	if c.snapErr != nil {
+		return c.snapErr
+	}
+	return gob.NewEncoder(w).Encode(c.data)
+}
+
+func (c *mapCache) Restore(r io.Reader) error {
+	c.data = map[string]int{}
+	return gob.NewDecoder(r).Decode(&c.data)
+}
+
+func TestLog_Replay(t *testing.T) {
+	for _, codec := range []snapshot.Codec{snapshot.Gob, snapshot.JSON} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			dir := t.TempDir()
+			clk := clock.NewFake(time.Now())
+			cache := newMapCache()
+			log, err := Open[string, int](dir, cache, WithCodec(codec), WithSync(SyncAlways), WithClock(clk))
+			if err != nil {
+				t.Fatalf("open failed: %v", err)
+			}
+			log.Put("a", 1, 0)
+			log.Put("b", 2, 0)
+			log.Put("a", 3, 0)
+			log.Remove("b")
+			log.Put("c", 4, time.Hour)
+			log.Put("short", 5, time.Millisecond)
+			log.Put("d", 6, 0)
+			log.Expire("d")
+			if cache.data["a"] != 3 || len(cache.data) != 3 {
+				t.Fatalf("expected the log to apply changes to the cache, got %v", cache.data)
+			}
+			log.Close()
+			// short is already expired by the time it is replayed
+			clk.Advance(time.Second)
+
+			restored := newMapCache()
+			log, err = Open[string, int](dir, restored, WithCodec(codec), WithClock(clk))
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer log.Close()
+			want := map[string]int{"a": 3, "c": 4}
+			if len(restored.data) != len(want) || restored.data["a"] != 3 || restored.data["c"] != 4 {
+				t.Errorf("expected %v after replay but got %v", want, restored.data)
+			}
+			if _, ok := restored.expires["c"]; !ok {
+				t.Errorf("expected c to keep its ttl through replay")
+			}
+		})
+	}
+}
+
+func TestLog_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := Open[string, int](dir, newMapCache(), WithSync(SyncNever))
+	log.Put("a", 1, 0)
+	log.Put("b", 2, 0)
+	log.Close()
+
+	// chop the last record in half, as a crash mid-write would
+	path := filepath.Join(dir, "oplog.0")
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newMapCache()
+	log, err := Open[string, int](dir, cache)
+	if err != nil {
+		t.Fatalf("expected a torn record to be skipped but got %v", err)
+	}
+	if len(cache.data) != 1 || cache.data["a"] != 1 {
+		t.Errorf("expected only a replayed but got %v", cache.data)
+	}
+	log.Put("c", 3, 0)
+	log.Close()
+
+	cache = newMapCache()
+	log, _ = Open[string, int](dir, cache)
+	defer log.Close()
+	if len(cache.data) != 2 || cache.data["c"] != 3 {
+		t.Errorf("expected appends after the cut to replay cleanly but got %v", cache.data)
+	}
+}
+
+func TestLog_CorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := Open[string, int](dir, newMapCache(), WithSync(SyncNever))
+	log.Put("a", 1, 0)
+	log.Close()
+
+	// a frame claiming 4 GiB, replay has to stop rather than allocate it
+	f, _ := os.OpenFile(filepath.Join(dir, "oplog.0"), os.O_APPEND|os.O_WRONLY, 0)
+	f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	f.Close()
+
+	cache := newMapCache()
+	log, err := Open[string, int](dir, cache)
+	if err != nil {
+		t.Fatalf("expected a bad length to end the replay but got %v", err)
+	}
+	defer log.Close()
+	if len(cache.data) != 1 || cache.data["a"] != 1 {
+		t.Errorf("expected only a replayed but got %v", cache.data)
+	}
+}
+
+func TestLog_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	cache := newMapCache()
+	log, _ := Open[string, int](dir, cache, WithCompactAt(2048), WithSync(SyncNever))
+	for i := 0; i < 200; i++ {
+		log.Put(strconv.Itoa(i%10), i, 0)
+	}
+	if log.gen == 0 {
+		t.Fatalf("expected the log to compact past the threshold")
+	}
+	if log.size >= 2048 {
+		t.Errorf("expected the current log to stay under the threshold but it is %d bytes", log.size)
+	}
+	gens, _ := log.generations()
+	if len(gens) != 1 || gens[0] != log.gen {
+		t.Errorf("expected only generation %d left on disk but found %v", log.gen, gens)
+	}
+	log.Remove("0")
+	log.Close()
+
+	restored := newMapCache()
+	log, _ = Open[string, int](dir, restored)
+	defer log.Close()
+	if len(restored.data) != 9 || restored.data["9"] != 199 {
+		t.Errorf("expected snapshot plus log to rebuild the cache but got %v", restored.data)
+	}
+	if _, ok := restored.data["0"]; ok {
+		t.Errorf("expected the remove after compaction to be replayed")
+	}
+}
+
+func TestLog_CompactionFailure(t *testing.T) {
+	dir := t.TempDir()
+	cache := newMapCache()
+	log, _ := Open[string, int](dir, cache, WithCompactAt(256), WithSync(SyncNever))
+	var failures []error
+	log.OnCompactError(func(err error) { failures = append(failures, err) })
+
+	errDisk := errors.New("disk full")
+	cache.snapErr = errDisk
+	for i := 0; i < 20; i++ {
+		if err := log.Put(strconv.Itoa(i), i, 0); err != nil {
+			t.Fatalf("expected the write to succeed despite compaction but got %v", err)
+		}
+	}
+	if len(failures) == 0 || !errors.Is(failures[0], errDisk) {
+		t.Fatalf("expected compaction failures reported but got %v", failures)
+	}
+	if log.gen != 0 {
+		t.Errorf("expected to stay on generation 0 but got %d", log.gen)
+	}
+
+	cache.snapErr = nil
+	log.Put("last", 99, 0)
+	if log.gen != 1 {
+		t.Errorf("expected the next write to retry compaction")
+	}
+	log.Close()
+
+	cache = newMapCache()
+	log, _ = Open[string, int](dir, cache)
+	defer log.Close()
+	if len(cache.data) != 21 || cache.data["last"] != 99 {
+		t.Errorf("expected every write recovered but got %v", cache.data)
+	}
+}
+
+func TestLog_Closed(t *testing.T) {
+	log, _ := Open[string, int](t.TempDir(), newMapCache())
+	log.Close()
+	if err := log.Put("a", 1, 0); err != os.ErrClosed {
+		t.Errorf("expected ErrClosed but got %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Errorf("expected a second Close to be a no-op but got %v", err)
+	}
+}
+
+// gob, except that encoding fails while fail is set
+type flakyCodec struct {
+	snapshot.Codec
+	fail *bool
+}
+
+type flakyEncoder struct {
+	snapshot.Encoder
+	fail *bool
+}
+
+func (c flakyCodec) NewEncoder(w io.Writer) snapshot.Encoder {
+	return flakyEncoder{c.Codec.NewEncoder(w), c.fail}
+}
+
+func (e flakyEncoder) Encode(v any) error {
+	if *e.fail {
+		return errors.New("unencodable")
+	}
+	return e.Encoder.Encode(v)
+}
+
+func TestLog_FailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	fail := false
+	codec := flakyCodec{snapshot.Gob, &fail}
+	cache := newMapCache()
+	log, _ := Open[string, int](dir, cache, WithCodec(codec), WithSync(SyncNever))
+	log.Put("a", 1, 0)
+	size := log.size
+
+	fail = true
+	if err := log.Put("b", 2, 0); err == nil {
+		t.Fatalf("expected the failed encode to be returned")
+	}
+	if _, ok := cache.data["b"]; ok {
+		t.Errorf("expected a record the log couldn't write to stay out of the cache")
+	}
+	if log.size != size {
+		t.Errorf("expected the log to stay at %d bytes but it is %d", size, log.size)
+	}
+	fail = false
+	log.Put("c", 3, 0)
+	log.Close()
+
+	restored := newMapCache()
+	log, _ = Open[string, int](dir, restored, WithCodec(codec))
+	defer log.Close()
+	if len(restored.data) != 2 || restored.data["a"] != 1 || restored.data["c"] != 3 {
+		t.Errorf("expected the records around the failure to replay but got %v", restored.data)
+	}
+}
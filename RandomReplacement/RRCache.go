@@ -5,55 +5,126 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
 )
 
 type RandomCache[K comparable, V any] struct {
-	mu   sync.Mutex
-	data map[K]Entry[V]
-	keys []K
-	cap  int
-	rnd  *rand.Rand
+	mu    sync.Mutex
+	data  map[K]Entry[V]
+	keys  *keySet[K]
+	cap   int
+	rnd   *rand.Rand
+	clock clock.Clock
+
+	volatile *keySet[K] // keys that carry a ttl, sampled by the sweeper
+	sweeping bool
+	sweep    clock.Timer
+
+	loader   Loader[K, V]
+	ahead    float64 // refresh-ahead fraction of the ttl, 0 when off
+	swr      time.Duration
+	sie      time.Duration
+	inflight sync.WaitGroup
+	negTTL   time.Duration // how long not found answers are kept, 0 when off
+	tombs    int           // tombstones among the entries
+	codec    snapshot.Codec
+
+	jitterFrac float64
+	jitterAbs  time.Duration
 }
 
 type Entry[V any] struct {
-	value    V
-	expireAt time.Time
+	value      V
+	expireAt   time.Time
+	ttl        time.Duration
+	refreshing bool
+	missing    bool // tombstone for a key the loader reported not found
+}
+
+type Option func(*options)
+
+type options struct {
+	clock  clock.Clock
+	source rand.Source
+	ahead  float64
+	swr    time.Duration
+	sie    time.Duration
+	negTTL time.Duration
+	codec  snapshot.Codec
+
+	jitterFrac float64
+	jitterAbs  time.Duration
 }
 
-func NewRandomCache[K comparable, V any](capacity int) (*RandomCache[K, V], error) {
+// WithClock swaps the wall clock used for ttl expiry, mostly for a clock.Fake
+// in tests.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithSeed makes eviction choices reproducible by seeding the cache's rng.
+func WithSeed(seed int64) Option {
+	return func(o *options) {
+		o.source = rand.NewSource(seed)
+	}
+}
+
+// WithSource draws eviction choices from src instead of a time seeded source.
+func WithSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
+
+func NewRandomCache[K comparable, V any](capacity int, opts ...Option) (*RandomCache[K, V], error) {
 	if capacity < 0 {
 		return nil, errors.New("capacity must be positive")
 	}
+	o := options{clock: clock.Real, codec: snapshot.Gob}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.source == nil {
+		o.source = rand.NewSource(time.Now().UnixNano())
+	}
 	cache := &RandomCache[K, V]{
-		data: make(map[K]Entry[V]),
-		keys: make([]K, 0, capacity),
-		cap:  capacity,
-		rnd:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		data:   make(map[K]Entry[V]),
+		keys:   newKeySet[K](capacity),
+		cap:    capacity,
+		rnd:    rand.New(o.source),
+		clock:  o.clock,
+		ahead:  o.ahead,
+		swr:    o.swr,
+		sie:    o.sie,
+		negTTL: o.negTTL,
+		codec:  o.codec,
+
+		volatile: newKeySet[K](0),
+
+		jitterFrac: o.jitterFrac,
+		jitterAbs:  o.jitterAbs,
 	}
 	return cache, nil
 }
 
 func (c *RandomCache[K, V]) evictRandom() {
-	if len(c.keys) == 0 {
+	if c.keys.len() == 0 {
 		return
 	}
 	// prioritizing removing the expired ones for only the first 5 since we are also setting up with TTL btw
 	for i := 0; i < 5; i++ {
-		idx := c.rnd.Intn(len(c.keys))
-		key := c.keys[idx]
+		key := c.keys.random(c.rnd)
 		entry := c.data[key]
 		if c.isExpired(entry) {
-			delete(c.data, key)
-			c.Remove(key)
+			c.drop(key)
 			return
 		}
 	}
-	idx := c.rnd.Intn(len(c.keys))
-	tbDeleted := c.keys[idx]
-	delete(c.data, tbDeleted)
-	lastIndex := len(c.keys) - 1
-	c.keys[idx] = c.keys[lastIndex]
-	c.keys = c.keys[:lastIndex]
+	c.drop(c.keys.random(c.rnd))
 }
 func (c *RandomCache[K, V]) Get(key K) (V, bool) {
 	c.mu.Lock()
@@ -65,11 +136,20 @@ func (c *RandomCache[K, V]) Get(key K) (V, bool) {
 		return zero, false
 	}
 	if c.isExpired(v) {
-		delete(c.data, key)
-		c.Remove(key)
+		// entries in their stale window stay around for Fetch
+		if v.missing || c.staleAge(v) > c.grace() {
+			c.drop(key)
+		}
+		var zero V
+		return zero, false
+	}
+	if v.missing {
 		var zero V
 		return zero, false
 	}
+	if c.dueForRefresh(v) {
+		c.refresh(key, v)
+	}
 	return v.value, true
 
 }
@@ -78,7 +158,13 @@ func (c *RandomCache[K, V]) Put(key K, val V) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if v, exists := c.data[key]; exists {
+		if c.revive(&v) {
+			// a tombstone's short ttl doesn't carry over to the value
+			v.expireAt, v.ttl = time.Time{}, 0
+			c.volatile.remove(key)
+		}
 		v.value = val
+		v.refreshing = false
 		c.data[key] = v
 		return
 	}
@@ -87,15 +173,14 @@ func (c *RandomCache[K, V]) Put(key K, val V) {
 	}
 
 	c.data[key] = Entry[V]{value: val, expireAt: time.Time{}}
-	c.keys = append(c.keys, key)
+	c.keys.add(key)
 }
 
 func (c *RandomCache[K, V]) Delete(key K) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if _, exists := c.data[key]; exists {
-		delete(c.data, key)
-		c.Remove(key)
+		c.drop(key)
 		return true
 	}
 	return false
@@ -106,17 +191,36 @@ func (c *RandomCache[K, V]) SetWithTTL(key K, val V, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	expireAt := c.clock.Now().Add(c.jittered(ttl))
 	if v, exists := c.data[key]; exists {
+		c.revive(&v)
 		v.value = val
-		v.expireAt = time.Now().Add(ttl)
+		v.expireAt = expireAt
+		v.ttl = ttl
+		v.refreshing = false
 		c.data[key] = v
+		c.volatile.add(key)
 		return
 	}
 	if c.cap <= len(c.data) {
 		c.evictRandom()
 	}
-	c.data[key] = Entry[V]{value: val, expireAt: time.Now().Add(ttl)}
-	c.keys = append(c.keys, key)
+	c.data[key] = Entry[V]{value: val, expireAt: expireAt, ttl: ttl}
+	c.keys.add(key)
+	c.volatile.add(key)
+}
+
+// Stats counts what the cache holds, tombstones left by not found loads are
+// kept apart from real entries.
+type Stats struct {
+	Entries    int
+	Tombstones int
+}
+
+func (c *RandomCache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Entries: len(c.data) - c.tombs, Tombstones: c.tombs}
 }
 
 // these are just util funcs
@@ -124,14 +228,7 @@ func (c *RandomCache[K, V]) Len() int {
 	return len(c.data)
 }
 func (c *RandomCache[K, V]) Remove(key K) {
-	for i, k := range c.keys {
-		if k == key {
-			last := len(c.keys) - 1
-			c.keys[i] = c.keys[last]
-			c.keys = c.keys[:last]
-			break
-		}
-	}
+	c.keys.remove(key)
 }
 func (c *RandomCache[K, V]) Capacity() int {
 	return c.cap
@@ -141,9 +238,21 @@ func (c *RandomCache[K, V]) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.data = make(map[K]Entry[V], c.cap)
-	c.keys = c.keys[:0]
+	c.keys.clear()
+	c.volatile.clear()
+	c.tombs = 0
+}
+
+// deletes the key from the map and both key lists
+func (c *RandomCache[K, V]) drop(key K) {
+	if c.data[key].missing {
+		c.tombs--
+	}
+	delete(c.data, key)
+	c.Remove(key)
+	c.volatile.remove(key)
 }
 
 func (c *RandomCache[K, V]) isExpired(e Entry[V]) bool {
-	return time.Now().After(e.expireAt) && !e.expireAt.IsZero()
+	return c.clock.Now().After(e.expireAt) && !e.expireAt.IsZero()
 }
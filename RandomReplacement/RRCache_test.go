@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"math/rand"
 	"strconv"
 	"testing"
+	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
 )
 
 func TestRandomCache_Eviction(t *testing.T) {
@@ -34,3 +41,365 @@ func TestRandomCache_Eviction(t *testing.T) {
 		})
 	}
 }
+
+func TestRandomCache_TTL(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewRandomCache[int, string](4, WithClock(clk))
+	c.SetWithTTL(1, "a", 500*time.Millisecond)
+	c.SetWithTTL(2, "b", 2*time.Second)
+	c.Put(3, "c")
+
+	clk.Advance(500 * time.Millisecond)
+	if v, ok := c.Get(1); !ok || v != "a" {
+		t.Errorf("expected 1 to live until its deadline but got %v", v)
+	}
+	clk.Advance(time.Millisecond)
+	if _, ok := c.Get(1); ok {
+		t.Errorf("expected 1 to expire")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected expired 1 to be dropped on read but got len %d", c.Len())
+	}
+
+	clk.Advance(time.Hour)
+	if _, ok := c.Get(2); ok {
+		t.Errorf("expected 2 to expire")
+	}
+	if v, ok := c.Get(3); !ok || v != "c" {
+		t.Errorf("expected 3 without ttl to stay but got %v", v)
+	}
+}
+
+func TestRandomCache_RefreshAhead(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewRandomCache[int, string](4, WithClock(clk), WithRefreshAhead(0.25))
+
+	release := make(chan struct{})
+	calls := 0
+	c.SetLoader(func(key int) (string, error) {
+		calls++
+		<-release
+		return "fresh", nil
+	})
+	c.SetWithTTL(1, "a", time.Second)
+	c.Put(2, "b")
+
+	clk.Advance(800 * time.Millisecond)
+	c.Get(1)
+	c.Get(2)
+	if v, ok := c.Get(1); !ok || v != "a" {
+		t.Errorf("expected old value while the refresh runs but got %v", v)
+	}
+	close(release)
+	c.inflight.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected one refresh for the ttl entry only but got %d", calls)
+	}
+	clk.Advance(900 * time.Millisecond)
+	if v, ok := c.Get(1); !ok || v != "fresh" {
+		t.Errorf("expected refreshed value with a new ttl but got %v", v)
+	}
+}
+
+func TestRandomCache_StopWaitsForRefresh(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewRandomCache[int, string](4, WithClock(clk), WithRefreshAhead(0.3))
+
+	release := make(chan struct{})
+	c.SetLoader(func(key int) (string, error) {
+		<-release
+		return "fresh", nil
+	})
+	c.SetWithTTL(1, "a", time.Second)
+	clk.Advance(800 * time.Millisecond)
+	c.Get(1)
+
+	stopped := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(stopped)
+	}()
+	close(release)
+	<-stopped
+
+	if v, ok := c.Get(1); !ok || v != "fresh" {
+		t.Errorf("expected the refresh written before Stop returned but got %v", v)
+	}
+}
+
+func TestRandomCache_Stale(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewRandomCache[int, string](4, WithClock(clk),
+		WithStaleWhileRevalidate(time.Second), WithStaleIfError(5*time.Second))
+
+	errUpstream := errors.New("upstream down")
+	var loadErr error
+	calls := 0
+	c.SetLoader(func(key int) (string, error) {
+		calls++
+		return "fresh", loadErr
+	})
+
+	c.SetWithTTL(1, "a", time.Second)
+	c.SetWithTTL(2, "b", time.Second)
+	clk.Advance(1500 * time.Millisecond)
+
+	if _, ok := c.Get(1); ok {
+		t.Errorf("expected Get to treat a stale entry as a miss")
+	}
+	if v, stale, err := c.Fetch(1, time.Second); err != nil || !stale || v != "a" {
+		t.Errorf("expected stale a while revalidating but got %v %v %v", v, stale, err)
+	}
+	c.inflight.Wait()
+	if v, stale, _ := c.Fetch(1, time.Second); stale || v != "fresh" {
+		t.Errorf("expected revalidated value but got %v stale: %v", v, stale)
+	}
+	if calls != 1 {
+		t.Errorf("expected a single revalidation but got %d loads", calls)
+	}
+
+	loadErr = errUpstream
+	clk.Advance(2 * time.Second)
+	if v, stale, err := c.Fetch(2, time.Second); err != nil || !stale || v != "b" {
+		t.Errorf("expected stale b on loader error but got %v %v %v", v, stale, err)
+	}
+	clk.Advance(3 * time.Second)
+	if _, _, err := c.Fetch(2, time.Second); !errors.Is(err, errUpstream) {
+		t.Errorf("expected loader error once the grace window is over but got %v", err)
+	}
+	if _, ok := c.data[2]; ok {
+		t.Errorf("expected 2 to be dropped after its grace window")
+	}
+}
+
+func TestRandomCache_Sweeper(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewRandomCache[int, string](300, WithClock(clk), WithSeed(1))
+	for i := 0; i < 100; i++ {
+		c.SetWithTTL(i, "short", time.Second)
+		c.SetWithTTL(100+i, "long", time.Hour)
+	}
+	for i := 200; i < 250; i++ {
+		c.Put(i, "forever")
+	}
+
+	c.StartSweeper(100*time.Millisecond, 20)
+	clk.Advance(time.Second)
+	if c.Len() != 250 {
+		t.Fatalf("expected nothing swept before expiry but got len %d", c.Len())
+	}
+	for i := 0; i < 100; i++ {
+		clk.Advance(100 * time.Millisecond)
+	}
+	if c.Len() != 150 || c.volatile.len() != 100 {
+		t.Errorf("expected all 100 expired keys swept without reads, got len %d and %d volatile", c.Len(), c.volatile.len())
+	}
+
+	c.StopSweeper()
+	c.SetWithTTL(1, "short", time.Second)
+	clk.Advance(time.Minute)
+	if c.Len() != 151 {
+		t.Errorf("expected no sweeping after stop but got len %d", c.Len())
+	}
+}
+
+func TestRandomCache_SeededEviction(t *testing.T) {
+	// keys evicted, in order, while filling a cache of 8 with 64 keys
+	evictions := func(opt Option) []int {
+		c, _ := NewRandomCache[int, int](8, opt)
+		var order []int
+		for i := 0; i < 64; i++ {
+			before := make(map[int]bool, len(c.data))
+			for k := range c.data {
+				before[k] = true
+			}
+			c.Put(i, i)
+			for k := range before {
+				if _, ok := c.data[k]; !ok {
+					order = append(order, k)
+				}
+			}
+			if i%5 == 0 {
+				c.Delete(i)
+			}
+		}
+		return order
+	}
+
+	first := evictions(WithSeed(42))
+	if len(first) == 0 {
+		t.Fatalf("expected evictions once the cache filled up")
+	}
+	tests := []struct {
+		name string
+		opt  Option
+		same bool
+	}{
+		{"same seed", WithSeed(42), true},
+		{"same source", WithSource(rand.NewSource(42)), true},
+		{"other seed", WithSeed(7), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evictions(tt.opt)
+			same := len(got) == len(first)
+			for i := 0; same && i < len(got); i++ {
+				same = got[i] == first[i]
+			}
+			if same != tt.same {
+				t.Errorf("expected identical eviction order: %v, got %v vs %v", tt.same, got, first)
+			}
+		})
+	}
+}
+
+func TestRandomCache_KeyIndex(t *testing.T) {
+	c, _ := NewRandomCache[int, string](100, WithSeed(1))
+	for i := 0; i < 100; i++ {
+		c.Put(i, "v")
+	}
+	for i := 0; i < 100; i += 3 {
+		c.Delete(i)
+	}
+	if c.keys.len() != c.Len() {
+		t.Fatalf("expected key index to track %d entries but has %d", c.Len(), c.keys.len())
+	}
+	for i, k := range c.keys.keys {
+		if c.keys.idx[k] != i {
+			t.Errorf("key %d indexed at %d but sits at %d", k, c.keys.idx[k], i)
+		}
+		if _, ok := c.data[k]; !ok {
+			t.Errorf("key %d indexed but not cached", k)
+		}
+	}
+}
+
+func TestRandomCache_Jitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      Option
+		min, max time.Duration
+	}{
+		{"fraction", WithJitter(0.5), time.Second, 1500 * time.Millisecond},
+		{"range", WithJitterRange(200 * time.Millisecond), time.Second, 1200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			start := clk.Now()
+			c, _ := NewRandomCache[int, int](100, WithClock(clk), WithSeed(1), tt.opt)
+
+			distinct := make(map[time.Time]bool)
+			for i := 0; i < 100; i++ {
+				c.SetWithTTL(i, i, time.Second)
+				at := c.data[i].expireAt
+				if at.Before(start.Add(tt.min)) || at.After(start.Add(tt.max)) {
+					t.Fatalf("expected expiry of %d within [%v, %v] but got %v", i, tt.min, tt.max, at.Sub(start))
+				}
+				if c.data[i].ttl != time.Second {
+					t.Errorf("expected the requested ttl kept for refreshes but got %v", c.data[i].ttl)
+				}
+				distinct[at] = true
+			}
+			if len(distinct) < 50 {
+				t.Errorf("expected jittered expiries to be spread out but only %d differ", len(distinct))
+			}
+		})
+	}
+}
+
+func TestRandomCache_NegativeCaching(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewRandomCache[string, int](10, WithClock(clk), WithNegativeTTL(100*time.Millisecond),
+		WithStaleIfError(time.Minute))
+
+	calls := map[string]int{}
+	c.SetLoader(func(key string) (int, error) {
+		calls[key]++
+		if key == "bogus" {
+			return 0, ErrNotFound
+		}
+		return len(key), nil
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := c.Fetch("bogus", time.Second); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound but got %v", err)
+		}
+	}
+	if calls["bogus"] != 1 {
+		t.Errorf("expected the tombstone to answer repeats but loader ran %d times", calls["bogus"])
+	}
+	if _, ok := c.Get("bogus"); ok {
+		t.Errorf("expected Get to miss on a tombstone")
+	}
+	c.Fetch("btc", time.Second)
+	if got := c.Stats(); got != (Stats{Entries: 1, Tombstones: 1}) {
+		t.Errorf("expected one entry and one tombstone but got %+v", got)
+	}
+
+	clk.Advance(150 * time.Millisecond)
+	c.Fetch("bogus", time.Second)
+	if calls["bogus"] != 2 {
+		t.Errorf("expected a new load once the tombstone expired but got %d", calls["bogus"])
+	}
+
+	// a plain Put turns the tombstone into a value without its short ttl
+	c.Put("bogus", 7)
+	clk.Advance(time.Hour)
+	if v, ok := c.Get("bogus"); !ok || v != 7 {
+		t.Errorf("expected Put to replace the tombstone for good but got %v %v", v, ok)
+	}
+	if got := c.Stats(); got.Tombstones != 0 {
+		t.Errorf("expected no tombstones left but got %+v", got)
+	}
+}
+
+func TestRandomCache_Snapshot(t *testing.T) {
+	for _, codec := range []snapshot.Codec{snapshot.Gob, snapshot.JSON} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			src, _ := NewRandomCache[string, int](8, WithClock(clk), WithCodec(codec))
+			for i := 0; i < 6; i++ {
+				src.Put(strconv.Itoa(i), i)
+			}
+			src.SetWithTTL("ttl", 7, time.Minute)
+			src.SetWithTTL("gone", 8, time.Second)
+			clk.Advance(2 * time.Second)
+
+			var buf bytes.Buffer
+			if err := src.Snapshot(&buf); err != nil {
+				t.Fatalf("snapshot failed: %v", err)
+			}
+			dst, _ := NewRandomCache[string, int](8, WithClock(clk), WithCodec(codec))
+			dst.Put("old", 0)
+			if err := dst.Restore(&buf); err != nil {
+				t.Fatalf("restore failed: %v", err)
+			}
+			if dst.Len() != 7 {
+				t.Fatalf("expected 7 live entries restored but got %d", dst.Len())
+			}
+			if _, ok := dst.Get("old"); ok {
+				t.Errorf("expected restore to replace the old contents")
+			}
+			if v, ok := dst.Get("3"); !ok || v != 3 {
+				t.Errorf("expected 3 restored but got %v %v", v, ok)
+			}
+			// the key set keeps its order, minus the expired key
+			src.Delete("gone")
+			if len(dst.keys.keys) != len(src.keys.keys) {
+				t.Fatalf("expected key order %v but got %v", src.keys.keys, dst.keys.keys)
+			}
+			for i := range src.keys.keys {
+				if dst.keys.keys[i] != src.keys.keys[i] {
+					t.Fatalf("expected key order %v but got %v", src.keys.keys, dst.keys.keys)
+				}
+			}
+			clk.Advance(time.Minute)
+			if _, ok := dst.Get("ttl"); ok {
+				t.Errorf("expected the ttl to survive the restore")
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "time"
+
+// WithJitter stretches every ttl passed to SetWithTTL by a random amount up
+// to fraction of it, so keys written together don't all expire at once.
+func WithJitter(fraction float64) Option {
+	return func(o *options) {
+		o.jitterFrac = fraction
+	}
+}
+
+// WithJitterRange stretches every ttl passed to SetWithTTL by a random amount
+// up to spread. It adds to WithJitter when both are given.
+func WithJitterRange(spread time.Duration) Option {
+	return func(o *options) {
+		o.jitterAbs = spread
+	}
+}
+
+// ttl plus its random share of the configured jitter, caller holds the lock
+func (c *RandomCache[K, V]) jittered(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return ttl
+	}
+	spread := time.Duration(float64(ttl)*c.jitterFrac) + c.jitterAbs
+	if spread <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(c.rnd.Int63n(int64(spread)+1))
+}
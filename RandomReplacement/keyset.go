@@ -0,0 +1,51 @@
+package main
+
+import "math/rand"
+
+// set of keys backed by a slice for random picks and an index map so
+// removing a key is a swap with the last slot instead of a scan
+type keySet[K comparable] struct {
+	keys []K
+	idx  map[K]int
+}
+
+func newKeySet[K comparable](capacity int) *keySet[K] {
+	return &keySet[K]{
+		keys: make([]K, 0, capacity),
+		idx:  make(map[K]int, capacity),
+	}
+}
+
+func (s *keySet[K]) add(key K) {
+	if _, exists := s.idx[key]; exists {
+		return
+	}
+	s.idx[key] = len(s.keys)
+	s.keys = append(s.keys, key)
+}
+
+func (s *keySet[K]) remove(key K) bool {
+	i, exists := s.idx[key]
+	if !exists {
+		return false
+	}
+	last := len(s.keys) - 1
+	s.keys[i] = s.keys[last]
+	s.idx[s.keys[i]] = i
+	s.keys = s.keys[:last]
+	delete(s.idx, key)
+	return true
+}
+
+func (s *keySet[K]) random(rnd *rand.Rand) K {
+	return s.keys[rnd.Intn(len(s.keys))]
+}
+
+func (s *keySet[K]) len() int {
+	return len(s.keys)
+}
+
+func (s *keySet[K]) clear() {
+	s.keys = s.keys[:0]
+	clear(s.idx)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNoLoader = errors.New("no loader registered")
+
+// ErrNotFound is returned by a loader when the source has no value for the
+// key, with WithNegativeTTL set the cache remembers the answer.
+var ErrNotFound = errors.New("not found")
+
+// Loader fetches the current value for a key from the backing source.
+type Loader[K comparable, V any] func(key K) (V, error)
+
+// WithRefreshAhead reloads a ttl entry in the background when it is read
+// with less than fraction of its ttl left, the old value keeps being served
+// until the loader returns. It needs a loader registered with SetLoader.
+func WithRefreshAhead(fraction float64) Option {
+	return func(o *options) {
+		o.ahead = fraction
+	}
+}
+
+// WithStaleWhileRevalidate keeps expired entries for d longer, Fetch serves
+// them flagged as stale while a single background reload runs.
+func WithStaleWhileRevalidate(d time.Duration) Option {
+	return func(o *options) {
+		o.swr = d
+	}
+}
+
+// WithStaleIfError keeps expired entries for d longer, Fetch falls back to
+// them flagged as stale when the loader fails.
+func WithStaleIfError(d time.Duration) Option {
+	return func(o *options) {
+		o.sie = d
+	}
+}
+
+// WithNegativeTTL keeps a tombstone for d when the loader returns
+// ErrNotFound, so Fetch answers repeated lookups of the key without the
+// loader.
+func WithNegativeTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.negTTL = d
+	}
+}
+
+func (c *RandomCache[K, V]) SetLoader(loader Loader[K, V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loader = loader
+}
+
+func (c *RandomCache[K, V]) dueForRefresh(e Entry[V]) bool {
+	if c.loader == nil || c.ahead <= 0 || e.refreshing || e.expireAt.IsZero() {
+		return false
+	}
+	left := e.expireAt.Sub(c.clock.Now())
+	return left <= time.Duration(float64(e.ttl)*c.ahead)
+}
+
+// reloads the entry on its own goroutine, caller holds the lock
+func (c *RandomCache[K, V]) refresh(key K, e Entry[V]) {
+	e.refreshing = true
+	c.data[key] = e
+	loader := c.loader
+	c.inflight.Add(1)
+	go func() {
+		defer c.inflight.Done()
+		v, err := loader(key)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		cur, exists := c.data[key]
+		// a write or delete in the meantime wins over the refresh
+		if !exists || !cur.refreshing {
+			return
+		}
+		cur.refreshing = false
+		if err == nil {
+			cur.value = v
+			cur.expireAt = c.clock.Now().Add(cur.ttl)
+		}
+		c.data[key] = cur
+	}()
+}
+
+func (c *RandomCache[K, V]) grace() time.Duration {
+	return max(c.swr, c.sie)
+}
+
+// how long ago the entry expired
+func (c *RandomCache[K, V]) staleAge(e Entry[V]) time.Duration {
+	return c.clock.Now().Sub(e.expireAt)
+}
+
+// Fetch returns the cached value for key, loading and caching it for ttl on
+// a miss. With a stale window configured an expired entry is returned with
+// stale set, either while it gets revalidated in the background or because
+// loading a fresh value failed.
+func (c *RandomCache[K, V]) Fetch(key K, ttl time.Duration) (V, bool, error) {
+	if v, ok := c.Get(key); ok {
+		return v, false, nil
+	}
+
+	c.mu.Lock()
+	loader := c.loader
+	var staleV V
+	age, hasStale := time.Duration(0), false
+	if e, ok := c.data[key]; ok && e.missing && !c.isExpired(e) {
+		c.mu.Unlock()
+		var zero V
+		return zero, false, ErrNotFound
+	} else if ok && !e.missing && c.isExpired(e) {
+		age = c.staleAge(e)
+		hasStale = age <= c.grace()
+		staleV = e.value
+		if hasStale && age <= c.swr {
+			if loader != nil && !e.refreshing {
+				c.refresh(key, e)
+			}
+			c.mu.Unlock()
+			return staleV, true, nil
+		}
+	}
+	c.mu.Unlock()
+
+	if loader == nil {
+		var zero V
+		return zero, false, ErrNoLoader
+	}
+	v, err := loader(key)
+	if errors.Is(err, ErrNotFound) {
+		if c.negTTL > 0 {
+			c.setMissing(key)
+		}
+		var zero V
+		return zero, false, err
+	}
+	if err != nil {
+		if hasStale && age <= c.sie {
+			return staleV, true, nil
+		}
+		var zero V
+		return zero, false, err
+	}
+	c.SetWithTTL(key, v, ttl)
+	return v, false, nil
+}
+
+// stores a tombstone for key that lives for the negative ttl
+func (c *RandomCache[K, V]) setMissing(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := Entry[V]{expireAt: c.clock.Now().Add(c.negTTL), ttl: c.negTTL, missing: true}
+	if v, exists := c.data[key]; exists {
+		if !v.missing {
+			c.tombs++
+		}
+		c.data[key] = e
+		c.volatile.add(key)
+		return
+	}
+	if c.cap <= len(c.data) {
+		c.evictRandom()
+	}
+	c.data[key] = e
+	c.keys.add(key)
+	c.volatile.add(key)
+	c.tombs++
+}
+
+// turns a tombstone back into a regular entry before it gets a value
+func (c *RandomCache[K, V]) revive(e *Entry[V]) bool {
+	if !e.missing {
+		return false
+	}
+	e.missing = false
+	c.tombs--
+	return true
+}
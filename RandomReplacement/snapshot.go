@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+// WithCodec picks how Snapshot encodes keys and values, gob by default.
+func WithCodec(codec snapshot.Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+type randomRecord[K comparable, V any] struct {
+	Key      K
+	Value    V
+	ExpireAt int64 // unix nanos, 0 when the entry has no ttl
+	TTL      time.Duration
+	Missing  bool
+}
+
+// Snapshot writes every live entry and tombstone to w in the order of the
+// key set random picks are drawn from, along with their expiry times.
+func (c *RandomCache[K, V]) Snapshot(w io.Writer) error {
+	c.mu.Lock()
+	var records []randomRecord[K, V]
+	for _, key := range c.keys.keys {
+		e := c.data[key]
+		if c.isExpired(e) {
+			continue
+		}
+		rec := randomRecord[K, V]{Key: key, Value: e.value, TTL: e.ttl, Missing: e.missing}
+		if !e.expireAt.IsZero() {
+			rec.ExpireAt = e.expireAt.UnixNano()
+		}
+		records = append(records, rec)
+	}
+	c.mu.Unlock()
+
+	if err := snapshot.WriteHeader(w, "random", c.codec); err != nil {
+		return err
+	}
+	return snapshot.WriteRecords(w, c.codec, records)
+}
+
+// Restore replaces the cache contents with a snapshot, rebuilding the key
+// set in the same order so a seeded cache evicts as the original would.
+// Entries that expired since are skipped and the snapshot is cut at the
+// capacity. On error the cache is left as it was.
+func (c *RandomCache[K, V]) Restore(r io.Reader) error {
+	if err := snapshot.ReadHeader(r, "random", c.codec); err != nil {
+		return err
+	}
+	records, err := snapshot.ReadRecords[randomRecord[K, V]](r, c.codec)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = make(map[K]Entry[V], c.cap)
+	c.keys.clear()
+	c.volatile.clear()
+	c.tombs = 0
+	now := c.clock.Now().UnixNano()
+	for _, rec := range records {
+		if len(c.data) >= c.cap {
+			break
+		}
+		if rec.ExpireAt != 0 && now > rec.ExpireAt {
+			continue
+		}
+		e := Entry[V]{value: rec.Value, ttl: rec.TTL, missing: rec.Missing}
+		if rec.ExpireAt != 0 {
+			e.expireAt = time.Unix(0, rec.ExpireAt)
+			c.volatile.add(rec.Key)
+		}
+		if e.missing {
+			c.tombs++
+		}
+		c.data[rec.Key] = e
+		c.keys.add(rec.Key)
+	}
+	return nil
+}
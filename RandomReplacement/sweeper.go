@@ -0,0 +1,76 @@
+package main
+
+import "time"
+
+const (
+	// a sweep keeps going while more than this share of a sample expired
+	sweepRepeatRatio = 0.25
+	// upper bound on sampling rounds per sweep so one pass can't hog the lock
+	sweepMaxRounds = 16
+)
+
+// StartSweeper deletes expired entries in the background, Redis style.
+// Every interval it samples up to sampleSize keys that carry a ttl, drops the
+// expired ones and samples again straight away while more than a quarter of
+// the sample was expired.
+func (c *RandomCache[K, V]) StartSweeper(interval time.Duration, sampleSize int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sweeping || interval <= 0 || sampleSize <= 0 {
+		return
+	}
+	c.sweeping = true
+
+	var run func()
+	run = func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !c.sweeping {
+			return
+		}
+		c.sweepExpired(sampleSize)
+		c.sweep.Reset(interval)
+	}
+	c.sweep = c.clock.AfterFunc(interval, run)
+}
+
+// StopSweeper stops the background sweeps, a sweep already waiting on the
+// lock returns without doing anything.
+func (c *RandomCache[K, V]) StopSweeper() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.sweeping {
+		return
+	}
+	c.sweeping = false
+	c.sweep.Stop()
+}
+
+// Stop stops the sweeper if one runs and returns once refreshes already
+// running in the background have finished writing.
+func (c *RandomCache[K, V]) Stop() {
+	c.StopSweeper()
+	// refreshes take the lock to write, so wait without holding it
+	c.inflight.Wait()
+}
+
+// one sweep, returns how many entries went. caller holds the lock
+func (c *RandomCache[K, V]) sweepExpired(sampleSize int) int {
+	removed := 0
+	for round := 0; round < sweepMaxRounds && c.volatile.len() > 0; round++ {
+		n := min(sampleSize, c.volatile.len())
+		expired := 0
+		for i := 0; i < n && c.volatile.len() > 0; i++ {
+			key := c.volatile.random(c.rnd)
+			if e := c.data[key]; c.isExpired(e) && (e.missing || c.staleAge(e) > c.grace()) {
+				c.drop(key)
+				expired++
+			}
+		}
+		removed += expired
+		if float64(expired) <= float64(n)*sweepRepeatRatio {
+			break
+		}
+	}
+	return removed
+}
@@ -1,155 +1,264 @@
 package main
 
 import (
+	"errors"
+	"math/rand"
 	"sync"
 	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
 )
 
 type Node[K comparable, V any] struct {
 	key        K
 	value      V
 	expiryTime int64
+	expireTick uint64 // tick at which the wheel drops the entry
 	slot       *slot[K, V]
+	freq       int
+	lastUsed   uint64
+	heapIdx    int
+	idle       time.Duration // sliding window, 0 for a fixed expiry
+	deadline   int64         // hard cap on a sliding entry's lifetime, 0 for none
+	ttl        time.Duration // lifetime it was set with, reused by refreshes
+	refreshing bool
+	stale      bool // expired but kept for the stale grace window
+	missing    bool // tombstone for a key the loader reported not found
 	prev, next *Node[K, V]
 }
 
-type slot[K comparable, V any] struct {
-	head, tail *Node[K, V]
-}
+// NoExpiration passed as a ttl keeps the entry until it is deleted, it is
+// also what Remaining reports for such keys.
+const NoExpiration time.Duration = -1
+
+// expire tick of persisted entries, keeps them last in the soonest heap
+const neverTick = ^uint64(0)
 
 type TTLCache[K comparable, V any] struct {
 	cache    map[K]*Node[K, V]
 	wheel    [3][]slot[K, V] // 3 level timing wheel
-	tick     uint32          // global tick
+	overflow slot[K, V]      // entries beyond the wheel horizon
+	tick     uint64          // global tick, 1 ms since start
+	count    [4]int          // entries held per wheel level and in overflow
+	capacity int             // 0 means unbounded
+	sliding  bool
+	maxLife  time.Duration
+	evict    *evictHeap[K, V]
+	useSeq   uint64 // bumped on every access, orders entries for LRU
 	mu       sync.RWMutex
-	stopCh   chan struct{}
-	onceStop sync.Once
+	start    time.Time
+	clock    clock.Clock
+	timer    clock.Timer
+	wakeAt   uint64 // tick the timer is armed for
+	running  bool
+	onExpire func(key K, value V)
+	subs     []chan K
+	expired  []*Node[K, V] // waiting to be handed to listeners
+	loader   Loader[K, V]
+	ahead    float64 // refresh-ahead fraction of the ttl, 0 when off
+	swr      time.Duration
+	sie      time.Duration
+	inflight sync.WaitGroup
+	negTTL   time.Duration // how long not found answers are kept, 0 when off
+	tombs    int           // tombstones among the entries
+	codec    snapshot.Codec
+
+	rnd        *rand.Rand // nil unless jitter is configured
+	jitterFrac float64
+	jitterAbs  time.Duration
 }
 
-func NewTTLCache[K comparable, V any]() (*TTLCache[K, V], error) {
-	cache := &TTLCache[K, V]{
-		cache:  make(map[K]*Node[K, V]),
-		stopCh: make(chan struct{}),
-	}
+type Option func(*options)
 
-	cache.wheel[0] = make([]slot[K, V], 512) // wheel 0 -> 1 ms slots, 512 ms total
-	cache.wheel[1] = make([]slot[K, V], 256) // wheel 1 -> 512 ms slots, 131 s total
-	cache.wheel[2] = make([]slot[K, V], 256) // wheel 2 -> 131 s slots, ~9.4 h total
+type options struct {
+	capacity int
+	policy   EvictionPolicy
+	sliding  bool
+	maxLife  time.Duration
+	clock    clock.Clock
+	ahead    float64
+	swr      time.Duration
+	sie      time.Duration
+	negTTL   time.Duration
+	codec    snapshot.Codec
 
-	for i := 0; i < 3; i++ {
-		for j := range cache.wheel[i] {
-			headDummy := &Node[K, V]{}
-			tailDummy := &Node[K, V]{}
-			headDummy.next = tailDummy
-			tailDummy.prev = headDummy
+	jitterFrac float64
+	jitterAbs  time.Duration
+	jitterSeed int64
+}
 
-			cache.wheel[i][j].head = headDummy
-			cache.wheel[i][j].tail = tailDummy
-		}
+// WithCapacity bounds the cache to capacity live entries, once full the
+// policy picks which one makes room for a new key.
+func WithCapacity(capacity int, policy EvictionPolicy) Option {
+	return func(o *options) {
+		o.capacity = capacity
+		o.policy = policy
 	}
-	go cache.startTicker()
-	return cache, nil
 }
 
-// this is the global ticker
-func (c *TTLCache[K, V]) startTicker() {
-	ticker := time.NewTicker(time.Millisecond)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			c.mu.Lock()
-			c.tick++
-			c.expireWheel(0)
-			c.mu.Unlock()
-		case <-c.stopCh:
-			return
-		}
+// WithSliding makes every Set behave like SetSliding, the ttl becomes an
+// inactivity window and maxLifetime (0 for none) caps how long reads can
+// keep an entry alive.
+func WithSliding(maxLifetime time.Duration) Option {
+	return func(o *options) {
+		o.sliding = true
+		o.maxLife = maxLifetime
 	}
 }
 
-func (c *TTLCache[K, V]) expireWheel(level int) {
-	w := &c.wheel[level][c.tick%uint32(len(c.wheel[level]))]
-
-	for e := w.head.next; e != w.tail; {
-		next := e.next
-		if time.Now().UnixNano() >= e.expiryTime {
-			delete(c.cache, e.key)
-			w.remove(e)
-		}
-		e = next
+// WithClock swaps the wall clock for another time source, mostly a clock.Fake
+// in tests.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
 	}
+}
 
-	if c.tick%uint32(len(c.wheel[level])) == 0 && level+1 < 3 {
-		c.expireWheel(level + 1)
+func NewTTLCache[K comparable, V any](opts ...Option) (*TTLCache[K, V], error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
+	if o.capacity < 0 {
+		return nil, errors.New("capacity must not be negative")
+	}
+	cache := newTTLCache[K, V](o)
+	cache.running = true
+	return cache, nil
 }
 
-func (s *slot[K, V]) add(e *Node[K, V]) {
-	previous := s.tail.prev
-	previous.next = e
-	e.prev = previous
-	e.next = s.tail
-	s.tail.prev = e
-}
+func newTTLCache[K comparable, V any](o options) *TTLCache[K, V] {
+	if o.clock == nil {
+		o.clock = clock.Real
+	}
+	if o.codec == nil {
+		o.codec = snapshot.Gob
+	}
+	cache := &TTLCache[K, V]{
+		cache:    make(map[K]*Node[K, V]),
+		capacity: o.capacity,
+		sliding:  o.sliding,
+		maxLife:  o.maxLife,
+		clock:    o.clock,
+		start:    o.clock.Now(),
+		ahead:    o.ahead,
+		swr:      o.swr,
+		sie:      o.sie,
+		negTTL:   o.negTTL,
+		codec:    o.codec,
+		wakeAt:   neverTick,
 
-func (s *slot[K, V]) remove(e *Node[K, V]) {
-	if e.prev != nil {
-		e.prev.next = e.next
+		rnd:        o.jitterRand(),
+		jitterFrac: o.jitterFrac,
+		jitterAbs:  o.jitterAbs,
 	}
-	if e.next != nil {
-		e.next.prev = e.prev
+	if o.capacity > 0 {
+		cache.evict = &evictHeap[K, V]{policy: o.policy}
 	}
-	e.prev = nil
-	e.next = nil
+	cache.initWheels()
+	return cache
 }
+
 func (c *TTLCache[K, V]) Set(key K, value V, ttl time.Duration) {
-	expiry := time.Now().Add(ttl).UnixNano()
+	if c.sliding && ttl != NoExpiration {
+		c.SetSliding(key, value, ttl, c.maxLife)
+		return
+	}
+	c.set(key, value, ttl, 0, 0)
+}
+
+// SetSliding stores an entry that expires after idle without a read, every
+// Get restarts the window. maxLifetime (0 for none) is an absolute cap from
+// now that reads can't extend.
+func (c *TTLCache[K, V]) SetSliding(key K, value V, idle, maxLifetime time.Duration) {
+	ttl := idle
+	if maxLifetime > 0 && maxLifetime < ttl {
+		ttl = maxLifetime
+	}
+	c.set(key, value, ttl, idle, maxLifetime)
+}
+
+func (c *TTLCache[K, V]) set(key K, value V, ttl, idle, maxLifetime time.Duration) {
+	now := c.clock.Now()
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	c.sync(now)
 
-	if old, ok := c.cache[key]; ok {
-		if old.slot != nil {
-			old.slot.remove(old)
-		}
-		delete(c.cache, key)
+	// sliding windows restart on every read, only fixed expiries are jittered
+	life := ttl
+	if idle == 0 {
+		life = c.jittered(ttl)
 	}
 	e := &Node[K, V]{
 		key:        key,
 		value:      value,
-		expiryTime: expiry,
-	}
-
-	c.insertEntry(e, ttl)
-	c.cache[key] = e
-}
-func (c *TTLCache[K, V]) insertEntry(e *Node[K, V], ttl time.Duration) {
-	ms := ttl.Milliseconds()
-	var wheelIdx, slotIdx int
-
-	if ms < 512 {
-		wheelIdx = 0
-		slotIdx = int((c.tick + uint32(ms)) % 512)
-	} else if ms < 512*256 {
-		wheelIdx = 1
-		slotIdx = int((c.tick/512 + uint32(ms)/512) % 256)
-	} else {
-		if ms > 256*131072 {
-			ms = 256 * 131072
-			e.expiryTime = time.Now().Add(time.Duration(ms) * time.Millisecond).UnixNano()
-		}
-		wheelIdx = 2
-		slotIdx = int((c.tick/131072 + uint32(ms)/131072) % 256)
+		expiryTime: now.Add(life).UnixNano(),
+		expireTick: c.tick + ticksFor(life),
+		heapIdx:    -1,
+		idle:       idle,
+		ttl:        ttl,
 	}
+	if maxLifetime > 0 {
+		e.deadline = now.Add(maxLifetime).UnixNano()
+	}
+	if ttl == NoExpiration {
+		e.expiryTime = 0
+		e.expireTick = neverTick
+	}
+	c.put(e)
+}
 
-	slot := &c.wheel[wheelIdx][slotIdx]
-	slot.add(e)
-	e.slot = slot
+// links a new entry in place of whatever the key held, caller holds the
+// write lock
+func (c *TTLCache[K, V]) put(e *Node[K, V]) {
+	if old, ok := c.cache[e.key]; ok {
+		e.freq = old.freq
+		c.removeEntry(old)
+	} else if c.capacity > 0 && len(c.cache) >= c.capacity {
+		c.removeEntry(c.evict.victim())
+	}
+
+	if e.expiryTime != 0 {
+		c.insertEntry(e)
+	}
+	c.cache[e.key] = e
+	if e.missing {
+		c.tombs++
+	}
+	if c.evict != nil {
+		c.touch(e)
+		c.evict.add(e)
+	}
+}
+
+// records an access for the LRU and LFU policies
+func (c *TTLCache[K, V]) touch(e *Node[K, V]) {
+	c.useSeq++
+	e.lastUsed = c.useSeq
+	e.freq++
+}
+
+// unlinks the entry from its wheel slot, the eviction heap and the map
+func (c *TTLCache[K, V]) removeEntry(e *Node[K, V]) {
+	c.unlink(e)
+	if c.evict != nil {
+		c.evict.remove(e)
+	}
+	if e.missing {
+		c.tombs--
+	}
+	delete(c.cache, e.key)
 }
+
 func (c *TTLCache[K, V]) Get(key K) (V, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	// reads can move sliding entries between slots and reorder the LRU and
+	// LFU heaps, so they take the write lock
+	c.mu.Lock()
+	defer c.unlock()
+	now := c.clock.Now()
+	c.sync(now)
 
 	e, ok := c.cache[key]
 	if !ok {
@@ -157,15 +266,236 @@ func (c *TTLCache[K, V]) Get(key K) (V, bool) {
 		return zero, false
 	}
 
-	if time.Now().UnixNano() > e.expiryTime {
+	if !e.live(now.UnixNano()) || e.missing {
 		var zero V
 		return zero, false
 	}
 
+	if e.idle > 0 {
+		next := now.Add(e.idle)
+		if e.deadline != 0 && next.UnixNano() > e.deadline {
+			next = time.Unix(0, e.deadline)
+		}
+		c.reschedule(e, next)
+	}
+	if c.evict != nil && c.evict.policy != EvictSoonest {
+		c.touch(e)
+		c.evict.fix(e)
+	}
+	if c.dueForRefresh(e, now) {
+		c.refresh(e)
+	}
 	return e.value, true
 }
+
+func (c *TTLCache[K, V]) Delete(key K) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	c.sync(c.clock.Now())
+	if e, ok := c.cache[key]; ok {
+		c.removeEntry(e)
+		return true
+	}
+	return false
+}
+
+// Stats counts what the cache holds, tombstones left by not found loads are
+// kept apart from real entries.
+type Stats struct {
+	Entries    int
+	Tombstones int
+}
+
+func (c *TTLCache[K, V]) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Stats{Entries: len(c.cache) - c.tombs, Tombstones: c.tombs}
+}
+
+// Remaining returns how long the key has left to live, NoExpiration for a
+// persisted key and false if the key is missing or already expired.
+func (c *TTLCache[K, V]) Remaining(key K) (time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.clock.Now().UnixNano()
+	e, ok := c.cache[key]
+	if !ok || !e.live(now) || e.missing {
+		return 0, false
+	}
+	if e.expiryTime == 0 {
+		return NoExpiration, true
+	}
+	return time.Duration(e.expiryTime - now), true
+}
+
+// Touch restarts the key's ttl from now without rewriting its value. Like
+// ExpireAt it turns a sliding entry into a fixed one.
+func (c *TTLCache[K, V]) Touch(key K, ttl time.Duration) bool {
+	return c.ExpireAt(key, c.clock.Now().Add(ttl))
+}
+
+// Extend pushes the key's current expiry back by ttl, persisted keys stay
+// persisted.
+func (c *TTLCache[K, V]) Extend(key K, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	now := c.clock.Now()
+	c.sync(now)
+
+	e, ok := c.cache[key]
+	if !ok || !e.live(now.UnixNano()) || e.missing {
+		return false
+	}
+	if e.expiryTime != 0 {
+		c.reschedule(e, time.Unix(0, e.expiryTime).Add(ttl))
+	}
+	return true
+}
+
+// ExpireAt sets when the key expires. A sliding entry stops sliding, reads
+// would otherwise push the expiry past at.
+func (c *TTLCache[K, V]) ExpireAt(key K, at time.Time) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	now := c.clock.Now()
+	c.sync(now)
+
+	e, ok := c.cache[key]
+	if !ok || !e.live(now.UnixNano()) || e.missing {
+		return false
+	}
+	e.idle = 0
+	e.deadline = 0
+	c.reschedule(e, at)
+	return true
+}
+
+// Persist takes the key off the wheel so it never expires.
+func (c *TTLCache[K, V]) Persist(key K) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	now := c.clock.Now()
+	c.sync(now)
+
+	e, ok := c.cache[key]
+	if !ok || !e.live(now.UnixNano()) || e.missing {
+		return false
+	}
+	c.unlink(e)
+	e.expiryTime = 0
+	e.expireTick = neverTick
+	e.idle = 0
+	if c.evict != nil {
+		c.evict.fix(e)
+	}
+	return true
+}
+
+func (c *TTLCache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+}
+
+// drops every entry, caller holds the write lock
+func (c *TTLCache[K, V]) clear() {
+	c.cache = make(map[K]*Node[K, V])
+	c.count = [4]int{}
+	c.tombs = 0
+	c.initWheels()
+	if c.evict != nil {
+		c.evict.nodes = nil
+	}
+}
+
+// moves the entry to the wheel slot matching its new expiry
+func (c *TTLCache[K, V]) reschedule(e *Node[K, V], at time.Time) {
+	c.unlink(e)
+	e.stale = false
+	e.expiryTime = at.UnixNano()
+	e.expireTick = c.tick + ticksFor(at.Sub(c.clock.Now()))
+	c.insertEntry(e)
+	if c.evict != nil {
+		c.evict.fix(e)
+	}
+}
+
+// persisted entries have a zero expiry time and never go stale
+func (e *Node[K, V]) live(now int64) bool {
+	return e.expiryTime == 0 || now <= e.expiryTime
+}
+
+// Stop disarms the expiry timer, expired entries are then only dropped as
+// the cache gets used. It returns once refreshes already running in the
+// background have finished writing.
 func (c *TTLCache[K, V]) Stop() {
-	c.onceStop.Do(func() {
-		close(c.stopCh)
-	})
+	c.mu.Lock()
+	c.running = false
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	for _, ch := range c.subs {
+		close(ch)
+	}
+	c.subs = nil
+	c.mu.Unlock()
+	// refreshes take the lock to write, so wait without holding it
+	c.inflight.Wait()
+}
+
+// OnExpire registers fn to run for every entry the wheel expires. It is
+// called without the cache lock held so it may use the cache itself.
+func (c *TTLCache[K, V]) OnExpire(fn func(key K, value V)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onExpire = fn
+}
+
+// ExpiredKeys subscribes to expired keys on a channel with the given buffer.
+// Keys are dropped rather than blocking the cache when the buffer is full,
+// and the channel is closed by Stop.
+func (c *TTLCache[K, V]) ExpiredKeys(buffer int) <-chan K {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan K, buffer)
+	c.subs = append(c.subs, ch)
+	return ch
+}
+
+// how long the wheel keeps expired entries around for Fetch to serve stale
+func (c *TTLCache[K, V]) grace() time.Duration {
+	return max(c.swr, c.sie)
+}
+
+func (c *TTLCache[K, V]) listening() bool {
+	return c.onExpire != nil || len(c.subs) > 0
+}
+
+// releases the write lock, then hands whatever the wheel expired while it
+// was held to the expiry listeners
+func (c *TTLCache[K, V]) unlock() {
+	expired := c.expired
+	c.expired = nil
+	onExpire := c.onExpire
+	c.mu.Unlock()
+
+	for _, e := range expired {
+		if onExpire != nil {
+			onExpire(e.key, e.value)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, ch := range c.subs {
+		for _, e := range expired {
+			select {
+			case ch <- e.key:
+			default:
+			}
+		}
+	}
 }
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"testing"
 	"time"
+
+	"cacheEvicitonPolicies/internal/clock"
+	"cacheEvicitonPolicies/internal/snapshot"
 )
 
 func TestTTLCache_BasicOps(t *testing.T) {
@@ -38,13 +43,14 @@ func TestTTLCache_BasicOps(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c, err := NewTTLCache[int, string]()
+			clk := clock.NewFake(time.Now())
+			c, err := NewTTLCache[int, string](WithClock(clk))
 			if err != nil {
 				t.Fatalf("couldnt initialise cache: %v", err)
 			}
 			tt.setup(c)
 			tt.do(c)
-			time.Sleep(tt.sleep)
+			clk.Advance(tt.sleep)
 
 			for _, v := range tt.wantEvict {
 				got, ok := c.Get(v.key)
@@ -112,11 +118,798 @@ func TestTTLCache_Eviction(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c, _ := NewTTLCache[int, string]()
+			clk := clock.NewFake(time.Now())
+			c, _ := NewTTLCache[int, string](WithClock(clk))
 			tt.setup(c)
-			time.Sleep(tt.sleep)
+			clk.Advance(tt.sleep)
 			tt.check(t, c)
 			c.Stop()
 		})
 	}
 }
+
+func TestTTLCache_WheelCascade(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset uint64 // ticks advanced before the entry is set
+		ttl    time.Duration
+	}{
+		{"wheel 0", 0, 100 * time.Millisecond},
+		{"wheel 0 edge", 37, 511 * time.Millisecond},
+		{"wheel 1 edge", 0, 512 * time.Millisecond},
+		{"wheel 1", 300, 5 * time.Second},
+		{"wheel 1 unaligned", 1001, 90*time.Second + 7*time.Millisecond},
+		{"wheel 2 edge", 5, 131072 * time.Millisecond},
+		{"wheel 2", 123457, 10*time.Minute + 3*time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTTLCache[int, string](options{clock: clock.NewFake(time.Now())})
+			for i := uint64(0); i < tt.offset; i++ {
+				c.advance()
+			}
+			c.Set(1, "a", tt.ttl)
+			want := tt.offset + uint64(tt.ttl/time.Millisecond)
+
+			for c.tick < want+10 {
+				c.advance()
+				if _, ok := c.cache[1]; !ok {
+					break
+				}
+			}
+			if _, ok := c.cache[1]; ok {
+				t.Fatalf("entry still in cache at tick %d, wanted expiry at %d", c.tick, want)
+			}
+			// within one tick of the requested ttl
+			if c.tick < want || c.tick > want+1 {
+				t.Errorf("expired at tick %d, wanted %d (+1)", c.tick, want)
+			}
+		})
+	}
+}
+
+func TestTTLCache_Capacity(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    EvictionPolicy
+		setup     func(c *TTLCache[int, string])
+		wantEvict int
+	}{
+		{
+			name:   "soonest to expire",
+			policy: EvictSoonest,
+			setup: func(c *TTLCache[int, string]) {
+				c.Set(1, "a", 5*time.Second)
+				c.Set(2, "b", 2*time.Second)
+				c.Set(3, "c", 10*time.Second)
+				c.Get(2)
+				c.Set(4, "d", 10*time.Second)
+			},
+			wantEvict: 2,
+		},
+		{
+			name:   "lru",
+			policy: EvictLRU,
+			setup: func(c *TTLCache[int, string]) {
+				c.Set(1, "a", 5*time.Second)
+				c.Set(2, "b", 2*time.Second)
+				c.Set(3, "c", 10*time.Second)
+				c.Get(1)
+				c.Get(2)
+				c.Set(4, "d", 10*time.Second)
+			},
+			wantEvict: 3,
+		},
+		{
+			name:   "lfu",
+			policy: EvictLFU,
+			setup: func(c *TTLCache[int, string]) {
+				c.Set(1, "a", 5*time.Second)
+				c.Set(2, "b", 2*time.Second)
+				c.Set(3, "c", 10*time.Second)
+				c.Get(1)
+				c.Get(1)
+				c.Get(3)
+				c.Get(3)
+				c.Get(2)
+				c.Set(4, "d", 10*time.Second)
+			},
+			wantEvict: 2,
+		},
+		{
+			name:   "overwrite does not evict",
+			policy: EvictLRU,
+			setup: func(c *TTLCache[int, string]) {
+				c.Set(1, "a", 5*time.Second)
+				c.Set(2, "b", 5*time.Second)
+				c.Set(3, "c", 5*time.Second)
+				c.Set(1, "a2", 5*time.Second)
+				c.Set(4, "d", 5*time.Second)
+			},
+			wantEvict: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewTTLCache[int, string](WithCapacity(3, tt.policy))
+			if err != nil {
+				t.Fatalf("couldnt initialise cache: %v", err)
+			}
+			defer c.Stop()
+			tt.setup(c)
+
+			if got := len(c.cache); got != 3 {
+				t.Errorf("expected 3 entries but got %d", got)
+			}
+			if v, ok := c.Get(tt.wantEvict); ok {
+				t.Errorf("expected %d to be evicted but got %v", tt.wantEvict, v)
+			}
+		})
+	}
+}
+
+func TestTTLCache_CapacityExpiry(t *testing.T) {
+	c := newTTLCache[int, string](options{capacity: 2, policy: EvictLFU, clock: clock.NewFake(time.Now())})
+	c.Set(1, "a", 10*time.Millisecond)
+	c.Set(2, "b", time.Second)
+	for i := 0; i < 10; i++ {
+		c.advance()
+	}
+	if len(c.cache) != 1 || c.evict.Len() != 1 {
+		t.Fatalf("expired entry should leave map and heap, got %d and %d", len(c.cache), c.evict.Len())
+	}
+	c.Set(3, "c", time.Second)
+	if len(c.cache) != 2 {
+		t.Errorf("expected room for 3 after expiry but got %d entries", len(c.cache))
+	}
+	if _, err := NewTTLCache[int, string](WithCapacity(-1, EvictLRU)); err == nil {
+		t.Error("expected error for negative capacity")
+	}
+}
+
+func TestTTLCache_ExpiryControl(t *testing.T) {
+	tests := []struct {
+		name  string
+		do    func(c *TTLCache[int, string]) bool
+		ticks int // ticks advanced after do
+		want  bool
+	}{
+		{
+			name:  "delete",
+			do:    func(c *TTLCache[int, string]) bool { return c.Delete(1) },
+			ticks: 0,
+			want:  false,
+		},
+		{
+			name:  "touch pushes expiry",
+			do:    func(c *TTLCache[int, string]) bool { return c.Touch(1, time.Second) },
+			ticks: 200,
+			want:  true,
+		},
+		{
+			name:  "extend pushes expiry",
+			do:    func(c *TTLCache[int, string]) bool { return c.Extend(1, 600*time.Millisecond) },
+			ticks: 200,
+			want:  true,
+		},
+		{
+			name:  "expire at pulls expiry in",
+			do:    func(c *TTLCache[int, string]) bool { return c.ExpireAt(1, c.clock.Now().Add(20*time.Millisecond)) },
+			ticks: 30,
+			want:  false,
+		},
+		{
+			name:  "persist",
+			do:    func(c *TTLCache[int, string]) bool { return c.Persist(1) },
+			ticks: 200,
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTTLCache[int, string](options{clock: clock.NewFake(time.Now())})
+			c.Set(1, "a", 100*time.Millisecond)
+			if !tt.do(c) {
+				t.Fatalf("expected op on live key to succeed")
+			}
+			for i := 0; i < tt.ticks; i++ {
+				c.advance()
+			}
+			e, ok := c.cache[1]
+			if ok != tt.want {
+				t.Fatalf("expected key present: %v but got %v", tt.want, ok)
+			}
+			if ok && e.expiryTime != 0 && e.slot == nil {
+				t.Errorf("live key with expiry should sit in a wheel slot")
+			}
+		})
+	}
+}
+
+func TestTTLCache_RemainingAndClear(t *testing.T) {
+	c := newTTLCache[int, string](options{capacity: 4, clock: clock.NewFake(time.Now())})
+	c.Set(1, "a", time.Minute)
+	c.Set(2, "b", time.Minute)
+	c.Persist(2)
+
+	if d, ok := c.Remaining(1); !ok || d <= 59*time.Second || d > time.Minute {
+		t.Errorf("expected about a minute left but got %v", d)
+	}
+	if d, ok := c.Remaining(2); !ok || d != NoExpiration {
+		t.Errorf("expected persisted key to report NoExpiration but got %v", d)
+	}
+	if _, ok := c.Remaining(3); ok {
+		t.Errorf("expected missing key to report no ttl")
+	}
+	if c.Touch(3, time.Second) || c.Persist(3) || c.Delete(3) {
+		t.Errorf("expected ops on a missing key to fail")
+	}
+
+	c.Clear()
+	if len(c.cache) != 0 || c.evict.Len() != 0 {
+		t.Fatalf("expected cleared cache but got %d entries", len(c.cache))
+	}
+	c.Set(1, "a", 10*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		c.advance()
+	}
+	if _, ok := c.cache[1]; ok {
+		t.Errorf("expected wheel to keep expiring after clear")
+	}
+}
+
+func TestTTLCache_Sliding(t *testing.T) {
+	c := newTTLCache[int, string](options{clock: clock.NewFake(time.Now())})
+	c.SetSliding(1, "a", 100*time.Millisecond, 0)
+	c.Set(2, "b", 100*time.Millisecond)
+
+	for i := 0; i < 80; i++ {
+		c.advance()
+	}
+	c.Get(1)
+	c.Get(2)
+	for i := 0; i < 80; i++ {
+		c.advance()
+	}
+	if _, ok := c.cache[1]; !ok {
+		t.Fatalf("expected read to slide 1 past its first expiry")
+	}
+	if _, ok := c.cache[2]; ok {
+		t.Errorf("expected fixed ttl entry 2 to expire despite the read")
+	}
+	for i := 0; i < 30; i++ {
+		c.advance()
+	}
+	if _, ok := c.cache[1]; ok {
+		t.Errorf("expected 1 to expire once left idle")
+	}
+}
+
+func TestTTLCache_SlidingMaxLifetime(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewTTLCache[int, string](WithSliding(250*time.Millisecond), WithClock(clk))
+	defer c.Stop()
+	c.Set(1, "a", 100*time.Millisecond)
+
+	for i := 0; i < 6; i++ {
+		clk.Advance(40 * time.Millisecond)
+		if _, ok := c.Get(1); !ok {
+			t.Fatalf("expected 1 to stay alive while read, gone after %d reads", i)
+		}
+	}
+	clk.Advance(5 * time.Millisecond)
+	if _, ok := c.Get(1); !ok {
+		t.Fatalf("expected 1 to live right up to its max lifetime")
+	}
+	clk.Advance(6 * time.Millisecond)
+	if _, ok := c.cache[1]; ok {
+		t.Errorf("expected 1 to expire at its max lifetime")
+	}
+}
+
+func TestTTLCache_SlidingExpireAt(t *testing.T) {
+	tests := []struct {
+		name string
+		do   func(c *TTLCache[int, string], now time.Time) bool
+	}{
+		{"expire at", func(c *TTLCache[int, string], now time.Time) bool { return c.ExpireAt(1, now.Add(10*time.Millisecond)) }},
+		{"touch", func(c *TTLCache[int, string], now time.Time) bool { return c.Touch(1, 10*time.Millisecond) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			c, _ := NewTTLCache[int, string](WithClock(clk))
+			defer c.Stop()
+			c.SetSliding(1, "a", 100*time.Millisecond, 0)
+			if !tt.do(c, clk.Now()) {
+				t.Fatalf("expected the sliding entry to accept an explicit expiry")
+			}
+			clk.Advance(5 * time.Millisecond)
+			c.Get(1)
+			clk.Advance(20 * time.Millisecond)
+			if _, ok := c.Get(1); ok {
+				t.Errorf("expected the read not to slide past the explicit expiry")
+			}
+		})
+	}
+}
+
+func TestTTLCache_LazyAdvance(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewTTLCache[int, string](WithClock(clk))
+	defer c.Stop()
+	if c.timer != nil {
+		t.Fatalf("expected an empty cache to never arm its timer")
+	}
+
+	c.Set(1, "a", 20*time.Millisecond)
+	c.mu.Lock()
+	armed := c.wakeAt
+	c.mu.Unlock()
+	if armed == neverTick || c.timer == nil {
+		t.Fatalf("expected timer to be armed for the new entry")
+	}
+
+	clk.Advance(20 * time.Millisecond)
+	c.mu.Lock()
+	_, ok := c.cache[1]
+	idle := c.wakeAt == neverTick
+	c.mu.Unlock()
+	if ok {
+		t.Errorf("expected timer to expire 1 without any reads")
+	}
+	if !idle {
+		t.Errorf("expected timer to go idle once the wheel is empty")
+	}
+}
+
+func TestTTLCache_CatchUp(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c := newTTLCache[int, string](options{clock: clk})
+	c.Set(1, "a", 5*time.Minute)
+	c.Set(2, "b", 20*time.Minute)
+
+	// nothing runs the wheel for ten minutes, then one op catches it up
+	clk.Advance(10 * time.Minute)
+	c.Delete(3)
+
+	if c.tick < uint64(10*time.Minute/time.Millisecond) {
+		t.Errorf("expected tick to catch up with the clk but got %d", c.tick)
+	}
+	if _, ok := c.cache[1]; ok {
+		t.Errorf("expected 1 to expire during catch up")
+	}
+	if _, ok := c.cache[2]; !ok {
+		t.Errorf("expected 2 to outlive catch up")
+	}
+}
+
+func TestTTLCache_BeyondHorizon(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		crowd int // entries living twice as long, sharing the overflow list
+	}{
+		{"just past horizon", wheelHorizon*time.Millisecond + 3*time.Millisecond, 0},
+		{"one day", 24 * time.Hour, 0},
+		{"three days", 72*time.Hour + 17*time.Millisecond, 0},
+		{"thirty days", 30 * 24 * time.Hour, 0},
+		{"three days with company", 72 * time.Hour, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			c, _ := NewTTLCache[int, string](WithClock(clk))
+			defer c.Stop()
+			clk.Advance(1234 * time.Millisecond)
+			c.Set(1, "a", tt.ttl)
+			c.Set(2, "b", NoExpiration)
+			for i := 0; i < tt.crowd; i++ {
+				c.Set(10+i, "crowd", 2*tt.ttl)
+			}
+
+			clk.Advance(tt.ttl - time.Millisecond)
+			c.mu.Lock()
+			_, ok := c.cache[1]
+			c.mu.Unlock()
+			if !ok {
+				t.Fatalf("expected 1 to outlive the wheel horizon")
+			}
+			if d, ok := c.Remaining(1); !ok || d != time.Millisecond {
+				t.Errorf("expected 1ms left but got %v", d)
+			}
+
+			clk.Advance(2 * time.Millisecond)
+			c.mu.Lock()
+			_, ok = c.cache[1]
+			_, persisted := c.cache[2]
+			c.mu.Unlock()
+			if ok {
+				t.Errorf("expected 1 to expire on time")
+			}
+			if !persisted {
+				t.Errorf("expected NoExpiration entry to stay")
+			}
+			for i := 0; i < tt.crowd; i++ {
+				if _, ok := c.Get(10 + i); !ok {
+					t.Errorf("expected %d still waiting in overflow", 10+i)
+				}
+			}
+		})
+	}
+}
+
+func TestTTLCache_ExpiryNotifications(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewTTLCache[int, string](WithClock(clk))
+
+	var expired []int
+	c.OnExpire(func(key int, value string) {
+		// runs outside the lock, so the cache is usable from here
+		if _, ok := c.Get(key); ok {
+			t.Errorf("expected %d to be gone when its callback runs", key)
+		}
+		expired = append(expired, key)
+	})
+	keys := c.ExpiredKeys(1)
+
+	c.Set(1, "a", 10*time.Millisecond)
+	c.Set(2, "b", 20*time.Millisecond)
+	c.Set(3, "c", time.Minute)
+	clk.Advance(25 * time.Millisecond)
+
+	if len(expired) != 2 || expired[0] != 1 || expired[1] != 2 {
+		t.Errorf("expected callbacks for 1 and 2 in order but got %v", expired)
+	}
+	// buffer of one, the second key is dropped instead of blocking
+	if k := <-keys; k != 1 {
+		t.Errorf("expected 1 on the channel but got %d", k)
+	}
+	select {
+	case k := <-keys:
+		t.Errorf("expected full buffer to drop 2 but got %d", k)
+	default:
+	}
+
+	c.Delete(3)
+	c.Stop()
+	if _, ok := <-keys; ok {
+		t.Errorf("expected Stop to close the channel")
+	}
+	if len(expired) != 2 {
+		t.Errorf("expected delete not to count as expiry but got %v", expired)
+	}
+}
+
+func TestTTLCache_RefreshAhead(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewTTLCache[int, string](WithClock(clk), WithRefreshAhead(0.2))
+	defer c.Stop()
+
+	release := make(chan struct{})
+	calls := 0
+	c.SetLoader(func(key int) (string, error) {
+		calls++
+		<-release
+		return "fresh", nil
+	})
+	c.Set(1, "a", time.Second)
+
+	clk.Advance(700 * time.Millisecond)
+	if v, _ := c.Get(1); v != "a" {
+		t.Fatalf("expected no refresh with 30%% of the ttl left, got %v", v)
+	}
+	clk.Advance(150 * time.Millisecond)
+	c.Get(1)
+	if v, ok := c.Get(1); !ok || v != "a" {
+		t.Errorf("expected old value while the refresh runs but got %v", v)
+	}
+	close(release)
+	c.inflight.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected one refresh for repeated reads but got %d", calls)
+	}
+	clk.Advance(500 * time.Millisecond)
+	if v, ok := c.Get(1); !ok || v != "fresh" {
+		t.Errorf("expected refreshed value with a new ttl but got %v", v)
+	}
+}
+
+func TestTTLCache_StopWaitsForRefresh(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewTTLCache[int, string](WithClock(clk), WithRefreshAhead(0.2))
+
+	release := make(chan struct{})
+	c.SetLoader(func(key int) (string, error) {
+		<-release
+		return "fresh", nil
+	})
+	c.Set(1, "a", time.Second)
+	clk.Advance(850 * time.Millisecond)
+	c.Get(1)
+
+	stopped := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(stopped)
+	}()
+	close(release)
+	<-stopped
+
+	if v, ok := c.Get(1); !ok || v != "fresh" {
+		t.Errorf("expected the refresh written before Stop returned but got %v", v)
+	}
+}
+
+func TestTTLCache_Stale(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewTTLCache[int, string](WithClock(clk),
+		WithStaleWhileRevalidate(time.Second), WithStaleIfError(5*time.Second))
+	defer c.Stop()
+
+	errUpstream := errors.New("upstream down")
+	var loadErr error
+	calls := 0
+	c.SetLoader(func(key int) (string, error) {
+		calls++
+		return "fresh", loadErr
+	})
+
+	c.Set(1, "a", time.Second)
+	c.Set(2, "b", time.Second)
+	clk.Advance(1500 * time.Millisecond)
+
+	if _, ok := c.Get(1); ok {
+		t.Errorf("expected Get to treat a stale entry as a miss")
+	}
+	if v, stale, err := c.Fetch(1, time.Second); err != nil || !stale || v != "a" {
+		t.Errorf("expected stale a while revalidating but got %v %v %v", v, stale, err)
+	}
+	c.inflight.Wait()
+	if v, stale, _ := c.Fetch(1, time.Second); stale || v != "fresh" {
+		t.Errorf("expected revalidated value but got %v stale: %v", v, stale)
+	}
+	if calls != 1 {
+		t.Errorf("expected a single revalidation but got %d loads", calls)
+	}
+
+	// past the revalidate window the load is synchronous, a failure falls
+	// back to the stale value until the error window closes
+	loadErr = errUpstream
+	clk.Advance(2 * time.Second)
+	if v, stale, err := c.Fetch(2, time.Second); err != nil || !stale || v != "b" {
+		t.Errorf("expected stale b on loader error but got %v %v %v", v, stale, err)
+	}
+	clk.Advance(3 * time.Second)
+	if _, _, err := c.Fetch(2, time.Second); !errors.Is(err, errUpstream) {
+		t.Errorf("expected loader error once the grace window is over but got %v", err)
+	}
+	c.mu.Lock()
+	_, ok := c.cache[2]
+	c.mu.Unlock()
+	if ok {
+		t.Errorf("expected wheel to drop 2 after its grace window")
+	}
+}
+
+func TestTTLCache_Jitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		min, max time.Duration
+	}{
+		{"none", nil, time.Second, time.Second},
+		{"fraction", []Option{WithJitter(0.2)}, time.Second, 1200 * time.Millisecond},
+		{"range", []Option{WithJitterRange(300 * time.Millisecond)}, time.Second, 1300 * time.Millisecond},
+		{"both", []Option{WithJitter(0.1), WithJitterRange(100 * time.Millisecond)}, time.Second, 1200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			opts := append([]Option{WithClock(clk), WithJitterSeed(1)}, tt.opts...)
+			c, _ := NewTTLCache[int, int](opts...)
+			defer c.Stop()
+
+			for i := 0; i < 200; i++ {
+				c.Set(i, i, time.Second)
+			}
+			for i := 0; i < 200; i++ {
+				if got, _ := c.Remaining(i); got < tt.min || got > tt.max {
+					t.Fatalf("expected remaining ttl of %d in [%v, %v] but got %v", i, tt.min, tt.max, got)
+				}
+			}
+
+			wheels, overflow := c.SlotCounts()
+			used, total := 0, overflow
+			for _, level := range wheels {
+				for _, n := range level {
+					total += n
+					if n > 0 {
+						used++
+					}
+				}
+			}
+			if total != 200 {
+				t.Errorf("expected slot counts to add up to 200 but got %d", total)
+			}
+			if tt.opts == nil && used != 1 {
+				t.Errorf("expected unjittered entries to share one slot but they use %d", used)
+			}
+			if tt.opts != nil && used < 2 {
+				t.Errorf("expected jittered entries spread over several slots but they use %d", used)
+			}
+
+			clk.Advance(tt.max)
+			c.mu.Lock()
+			n := len(c.cache)
+			c.mu.Unlock()
+			if n != 0 {
+				t.Errorf("expected every entry expired after %v but %d are left", tt.max, n)
+			}
+		})
+	}
+}
+
+func TestTTLCache_JitterSkipsSliding(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewTTLCache[int, int](WithClock(clk), WithJitter(1))
+	defer c.Stop()
+
+	c.SetSliding(1, 1, time.Second, 0)
+	c.Set(2, 2, NoExpiration)
+	if got, _ := c.Remaining(1); got != time.Second {
+		t.Errorf("expected sliding window left alone but got %v", got)
+	}
+	if got, _ := c.Remaining(2); got != NoExpiration {
+		t.Errorf("expected persisted entry left alone but got %v", got)
+	}
+}
+
+func TestTTLCache_NegativeCaching(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c, _ := NewTTLCache[string, int](WithClock(clk), WithNegativeTTL(100*time.Millisecond),
+		WithStaleIfError(time.Minute))
+	defer c.Stop()
+
+	var expired []string
+	c.OnExpire(func(key string, _ int) { expired = append(expired, key) })
+	calls := map[string]int{}
+	c.SetLoader(func(key string) (int, error) {
+		calls[key]++
+		if key == "bogus" {
+			return 0, ErrNotFound
+		}
+		return len(key), nil
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := c.Fetch("bogus", time.Second); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound but got %v", err)
+		}
+	}
+	if calls["bogus"] != 1 {
+		t.Errorf("expected the tombstone to answer repeats but loader ran %d times", calls["bogus"])
+	}
+	if _, ok := c.Get("bogus"); ok {
+		t.Errorf("expected Get to miss on a tombstone")
+	}
+	if _, ok := c.Remaining("bogus"); ok {
+		t.Errorf("expected no remaining ttl for a tombstone")
+	}
+	if c.Persist("bogus") || c.Extend("bogus", time.Hour) || c.Touch("bogus", time.Hour) {
+		t.Errorf("expected expiry control to leave the tombstone alone")
+	}
+	c.Fetch("btc", time.Second)
+	if got := c.Stats(); got != (Stats{Entries: 1, Tombstones: 1}) {
+		t.Errorf("expected one entry and one tombstone but got %+v", got)
+	}
+
+	// tombstones expire on their own ttl, quietly and without a stale window
+	clk.Advance(150 * time.Millisecond)
+	if got := c.Stats(); got != (Stats{Entries: 1, Tombstones: 0}) {
+		t.Errorf("expected the tombstone gone after its ttl but got %+v", got)
+	}
+	if len(expired) != 0 {
+		t.Errorf("expected no expiry callbacks for tombstones but got %v", expired)
+	}
+	c.Fetch("bogus", time.Second)
+	if calls["bogus"] != 2 {
+		t.Errorf("expected a new load once the tombstone expired but got %d", calls["bogus"])
+	}
+
+	// a real value replaces the tombstone
+	c.Set("bogus", 7, time.Second)
+	if v, ok := c.Get("bogus"); !ok || v != 7 {
+		t.Errorf("expected Set to overwrite the tombstone but got %v %v", v, ok)
+	}
+	if got := c.Stats(); got.Tombstones != 0 || got.Entries != 2 {
+		t.Errorf("expected two entries and no tombstones but got %+v", got)
+	}
+}
+
+func TestTTLCache_Snapshot(t *testing.T) {
+	for _, codec := range []snapshot.Codec{snapshot.Gob, snapshot.JSON} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			src, _ := NewTTLCache[string, int](WithClock(clk), WithCodec(codec), WithCapacity(4, EvictLRU))
+			defer src.Stop()
+			src.Set("short", 1, time.Second)
+			src.Set("long", 2, 48*time.Hour)
+			src.Set("forever", 3, NoExpiration)
+			src.SetSliding("session", 4, time.Minute, 0)
+			src.Get("short")
+			clk.Advance(500 * time.Millisecond)
+
+			var buf bytes.Buffer
+			if err := src.Snapshot(&buf); err != nil {
+				t.Fatalf("snapshot failed: %v", err)
+			}
+			// restored half a second later, into a cache with its own clk
+			clk.Advance(200 * time.Millisecond)
+			dst, _ := NewTTLCache[string, int](WithClock(clk), WithCodec(codec), WithCapacity(4, EvictLRU))
+			defer dst.Stop()
+			dst.Set("old", 0, time.Hour)
+			if err := dst.Restore(&buf); err != nil {
+				t.Fatalf("restore failed: %v", err)
+			}
+
+			if _, ok := dst.Get("old"); ok {
+				t.Errorf("expected restore to replace the old contents")
+			}
+			if got, _ := dst.Remaining("short"); got != 300*time.Millisecond {
+				t.Errorf("expected short to keep its absolute expiry but has %v left", got)
+			}
+			if got, _ := dst.Remaining("forever"); got != NoExpiration {
+				t.Errorf("expected forever to stay persisted but got %v", got)
+			}
+			// the LRU order came along: long is the least recently used
+			dst.Set("new", 5, time.Hour)
+			if _, ok := dst.Remaining("long"); ok {
+				t.Errorf("expected long evicted as least recently used")
+			}
+			clk.Advance(300 * time.Millisecond)
+			if _, ok := dst.Get("short"); ok {
+				t.Errorf("expected short to expire on its original schedule")
+			}
+			if _, ok := dst.Get("session"); !ok {
+				t.Errorf("expected the sliding entry restored")
+			}
+			clk.Advance(59 * time.Second)
+			if _, ok := dst.Get("session"); !ok {
+				t.Errorf("expected the sliding window to restart on read after restore")
+			}
+			clk.Advance(49 * time.Hour)
+			if v, ok := dst.Get("forever"); !ok || v != 3 {
+				t.Errorf("expected the persisted entry to outlive everything but got %v %v", v, ok)
+			}
+		})
+	}
+}
+
+func TestTTLCache_RestoreSkipsExpired(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	src, _ := NewTTLCache[string, int](WithClock(clk))
+	defer src.Stop()
+	src.Set("a", 1, time.Second)
+	src.Set("b", 2, time.Hour)
+	var buf bytes.Buffer
+	src.Snapshot(&buf)
+
+	clk.Advance(2 * time.Second)
+	dst, _ := NewTTLCache[string, int](WithClock(clk))
+	defer dst.Stop()
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if got := dst.Stats(); got.Entries != 1 {
+		t.Errorf("expected only b restored but got %+v", got)
+	}
+	if err := dst.Restore(bytes.NewReader([]byte("CSNP\x01\x03lru\x03gob"))); !errors.Is(err, snapshot.ErrFormat) {
+		t.Errorf("expected an lru snapshot to be rejected but got %v", err)
+	}
+}
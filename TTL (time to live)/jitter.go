@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// WithJitter stretches every ttl passed to Set by a random amount up to
+// fraction of it, so keys written together don't all expire in the same slot.
+func WithJitter(fraction float64) Option {
+	return func(o *options) {
+		o.jitterFrac = fraction
+	}
+}
+
+// WithJitterRange stretches every ttl passed to Set by a random amount up to
+// spread. It adds to WithJitter when both are given.
+func WithJitterRange(spread time.Duration) Option {
+	return func(o *options) {
+		o.jitterAbs = spread
+	}
+}
+
+// WithJitterSeed makes the jitter reproducible.
+func WithJitterSeed(seed int64) Option {
+	return func(o *options) {
+		o.jitterSeed = seed
+	}
+}
+
+func (o options) jitterRand() *rand.Rand {
+	if o.jitterFrac <= 0 && o.jitterAbs <= 0 {
+		return nil
+	}
+	seed := o.jitterSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// ttl plus its random share of the configured jitter, caller holds the lock
+func (c *TTLCache[K, V]) jittered(ttl time.Duration) time.Duration {
+	if c.rnd == nil || ttl <= 0 {
+		return ttl
+	}
+	spread := time.Duration(float64(ttl)*c.jitterFrac) + c.jitterAbs
+	if spread <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(c.rnd.Int63n(int64(spread)+1))
+}
+
+// SlotCounts reports how many entries wait in each slot of the three wheel
+// levels (1 ms, 512 ms and ~131 s slots) and in the overflow list, which
+// shows how evenly expiry is spread.
+func (c *TTLCache[K, V]) SlotCounts() (wheels [3][]int, overflow int) {
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.unlock()
+	c.sync(now)
+
+	for level := range c.wheel {
+		wheels[level] = make([]int, len(c.wheel[level]))
+		for i := range c.wheel[level] {
+			wheels[level][i] = c.wheel[level][i].len()
+		}
+	}
+	return wheels, c.overflow.len()
+}
+
+func (s *slot[K, V]) len() int {
+	n := 0
+	for e := s.head.next; e != s.tail; e = e.next {
+		n++
+	}
+	return n
+}
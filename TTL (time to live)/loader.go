@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNoLoader = errors.New("no loader registered")
+
+// ErrNotFound is returned by a loader when the source has no value for the
+// key, with WithNegativeTTL set the cache remembers the answer.
+var ErrNotFound = errors.New("not found")
+
+// Loader fetches the current value for a key from the backing source.
+type Loader[K comparable, V any] func(key K) (V, error)
+
+// WithRefreshAhead reloads an entry in the background when it is read with
+// less than fraction of its ttl left, the old value keeps being served until
+// the loader returns. It needs a loader registered with SetLoader.
+func WithRefreshAhead(fraction float64) Option {
+	return func(o *options) {
+		o.ahead = fraction
+	}
+}
+
+// WithStaleWhileRevalidate keeps expired entries for d longer, Fetch serves
+// them flagged as stale while a single background reload runs.
+func WithStaleWhileRevalidate(d time.Duration) Option {
+	return func(o *options) {
+		o.swr = d
+	}
+}
+
+// WithStaleIfError keeps expired entries for d longer, Fetch falls back to
+// them flagged as stale when the loader fails.
+func WithStaleIfError(d time.Duration) Option {
+	return func(o *options) {
+		o.sie = d
+	}
+}
+
+// WithNegativeTTL keeps a tombstone for d when the loader returns
+// ErrNotFound, so Fetch answers repeated lookups of the key without the
+// loader.
+func WithNegativeTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.negTTL = d
+	}
+}
+
+func (c *TTLCache[K, V]) SetLoader(loader Loader[K, V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loader = loader
+}
+
+func (c *TTLCache[K, V]) dueForRefresh(e *Node[K, V], now time.Time) bool {
+	if c.loader == nil || c.ahead <= 0 || e.refreshing || e.expiryTime == 0 || e.idle > 0 {
+		return false
+	}
+	left := time.Duration(e.expiryTime - now.UnixNano())
+	return left <= time.Duration(float64(e.ttl)*c.ahead)
+}
+
+// reloads the entry on its own goroutine, caller holds the write lock
+func (c *TTLCache[K, V]) refresh(e *Node[K, V]) {
+	e.refreshing = true
+	loader := c.loader
+	c.inflight.Add(1)
+	go func() {
+		defer c.inflight.Done()
+		v, err := loader(e.key)
+
+		c.mu.Lock()
+		defer c.unlock()
+		c.sync(c.clock.Now())
+		e.refreshing = false
+		// the entry may have been overwritten, deleted or expired meanwhile
+		if err != nil || c.cache[e.key] != e {
+			return
+		}
+		e.value = v
+		c.reschedule(e, c.clock.Now().Add(e.ttl))
+	}()
+}
+
+// Fetch returns the cached value for key, loading and caching it for ttl on
+// a miss. With a stale window configured an expired entry is returned with
+// stale set, either while it gets revalidated in the background or because
+// loading a fresh value failed.
+func (c *TTLCache[K, V]) Fetch(key K, ttl time.Duration) (V, bool, error) {
+	if v, ok := c.Get(key); ok {
+		return v, false, nil
+	}
+
+	c.mu.Lock()
+	now := c.clock.Now()
+	c.sync(now)
+	loader := c.loader
+	var staleV V
+	age, hasStale := time.Duration(0), false
+	if e, ok := c.cache[key]; ok && e.missing && e.live(now.UnixNano()) {
+		c.unlock()
+		var zero V
+		return zero, false, ErrNotFound
+	} else if ok && !e.missing && e.expiryTime != 0 && !e.live(now.UnixNano()) {
+		age = time.Duration(now.UnixNano() - e.expiryTime)
+		hasStale = age <= c.grace()
+		staleV = e.value
+		if hasStale && age <= c.swr {
+			if loader != nil && !e.refreshing {
+				c.refresh(e)
+			}
+			c.unlock()
+			return staleV, true, nil
+		}
+	}
+	c.unlock()
+
+	if loader == nil {
+		var zero V
+		return zero, false, ErrNoLoader
+	}
+	v, err := loader(key)
+	if errors.Is(err, ErrNotFound) {
+		if c.negTTL > 0 {
+			c.setMissing(key)
+		}
+		var zero V
+		return zero, false, err
+	}
+	if err != nil {
+		if hasStale && age <= c.sie {
+			return staleV, true, nil
+		}
+		var zero V
+		return zero, false, err
+	}
+	c.Set(key, v, ttl)
+	return v, false, nil
+}
+
+// stores a tombstone for key that lives for the negative ttl
+func (c *TTLCache[K, V]) setMissing(key K) {
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.unlock()
+	c.sync(now)
+	c.put(&Node[K, V]{
+		key:        key,
+		expiryTime: now.Add(c.negTTL).UnixNano(),
+		expireTick: c.tick + ticksFor(c.negTTL),
+		heapIdx:    -1,
+		ttl:        c.negTTL,
+		missing:    true,
+	})
+}
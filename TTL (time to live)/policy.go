@@ -0,0 +1,83 @@
+package main
+
+import "container/heap"
+
+// EvictionPolicy decides which live entry goes when a bounded cache is full.
+type EvictionPolicy int
+
+const (
+	EvictSoonest EvictionPolicy = iota // entry closest to expiring
+	EvictLRU                           // least recently read or written
+	EvictLFU                           // least frequently read or written, oldest first on ties
+)
+
+// min-heap of the live entries ordered by the eviction policy, the root is
+// always the next victim
+type evictHeap[K comparable, V any] struct {
+	policy EvictionPolicy
+	nodes  []*Node[K, V]
+}
+
+func (h *evictHeap[K, V]) Len() int { return len(h.nodes) }
+
+func (h *evictHeap[K, V]) Less(i, j int) bool {
+	a, b := h.nodes[i], h.nodes[j]
+	switch h.policy {
+	case EvictLRU:
+		return a.lastUsed < b.lastUsed
+	case EvictLFU:
+		if a.freq != b.freq {
+			return a.freq < b.freq
+		}
+		return a.lastUsed < b.lastUsed
+	default:
+		if a.expireTick != b.expireTick {
+			return a.expireTick < b.expireTick
+		}
+		return a.lastUsed < b.lastUsed
+	}
+}
+
+func (h *evictHeap[K, V]) Swap(i, j int) {
+	h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i]
+	h.nodes[i].heapIdx = i
+	h.nodes[j].heapIdx = j
+}
+
+func (h *evictHeap[K, V]) Push(x any) {
+	e := x.(*Node[K, V])
+	e.heapIdx = len(h.nodes)
+	h.nodes = append(h.nodes, e)
+}
+
+func (h *evictHeap[K, V]) Pop() any {
+	last := len(h.nodes) - 1
+	e := h.nodes[last]
+	h.nodes[last] = nil
+	h.nodes = h.nodes[:last]
+	e.heapIdx = -1
+	return e
+}
+
+func (h *evictHeap[K, V]) add(e *Node[K, V]) {
+	heap.Push(h, e)
+}
+
+func (h *evictHeap[K, V]) remove(e *Node[K, V]) {
+	if e.heapIdx >= 0 {
+		heap.Remove(h, e.heapIdx)
+	}
+}
+
+func (h *evictHeap[K, V]) fix(e *Node[K, V]) {
+	if e.heapIdx >= 0 {
+		heap.Fix(h, e.heapIdx)
+	}
+}
+
+func (h *evictHeap[K, V]) victim() *Node[K, V] {
+	if len(h.nodes) == 0 {
+		return nil
+	}
+	return h.nodes[0]
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"cacheEvicitonPolicies/internal/snapshot"
+)
+
+// WithCodec picks how Snapshot encodes keys and values, gob by default.
+func WithCodec(codec snapshot.Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+type ttlRecord[K comparable, V any] struct {
+	Key      K
+	Value    V
+	ExpireAt int64 // unix nanos, 0 for a persisted entry
+	TTL      time.Duration
+	Idle     time.Duration
+	Deadline int64
+	Freq     int
+	LastUsed uint64
+	Missing  bool
+}
+
+// Snapshot writes every live entry and tombstone to w with its absolute
+// expiry time, sliding window and eviction bookkeeping, least recently used
+// first.
+func (c *TTLCache[K, V]) Snapshot(w io.Writer) error {
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	c.sync(now)
+	records := make([]ttlRecord[K, V], 0, len(c.cache))
+	for _, e := range c.cache {
+		if !e.live(now.UnixNano()) {
+			continue
+		}
+		records = append(records, ttlRecord[K, V]{
+			Key: e.key, Value: e.value, ExpireAt: e.expiryTime, TTL: e.ttl, Idle: e.idle,
+			Deadline: e.deadline, Freq: e.freq, LastUsed: e.lastUsed, Missing: e.missing,
+		})
+	}
+	c.unlock()
+	sort.Slice(records, func(i, j int) bool { return records[i].LastUsed < records[j].LastUsed })
+
+	if err := snapshot.WriteHeader(w, "ttl", c.codec); err != nil {
+		return err
+	}
+	return snapshot.WriteRecords(w, c.codec, records)
+}
+
+// Restore replaces the cache contents with a snapshot. Expiry times are
+// absolute, so entries that expired since are skipped and the rest keep
+// what was left of their ttl. On error the cache is left as it was.
+func (c *TTLCache[K, V]) Restore(r io.Reader) error {
+	if err := snapshot.ReadHeader(r, "ttl", c.codec); err != nil {
+		return err
+	}
+	records, err := snapshot.ReadRecords[ttlRecord[K, V]](r, c.codec)
+	if err != nil {
+		return err
+	}
+
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.unlock()
+	c.sync(now)
+	c.clear()
+	for _, rec := range records {
+		e := &Node[K, V]{
+			key:        rec.Key,
+			value:      rec.Value,
+			expiryTime: rec.ExpireAt,
+			expireTick: neverTick,
+			heapIdx:    -1,
+			idle:       rec.Idle,
+			deadline:   rec.Deadline,
+			ttl:        rec.TTL,
+			missing:    rec.Missing,
+		}
+		if !e.live(now.UnixNano()) {
+			continue
+		}
+		if e.expiryTime != 0 {
+			e.expireTick = c.tick + ticksFor(time.Duration(e.expiryTime-now.UnixNano()))
+		}
+		c.put(e)
+		e.freq = rec.Freq
+		e.lastUsed = rec.LastUsed
+		c.useSeq = max(c.useSeq, rec.LastUsed)
+		if c.evict != nil {
+			c.evict.fix(e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import "time"
+
+type slot[K comparable, V any] struct {
+	head, tail *Node[K, V]
+	level      int
+}
+
+// furthest tick distance the wheels can hold
+const wheelHorizon = 512 * 256 * 256
+
+// level of the overflow list holding entries past the wheel horizon
+const overflowLevel = 3
+
+// slot resolution in ticks for each wheel level, the overflow list is
+// revisited once per horizon
+var wheelRes = [4]uint64{1, 512, 512 * 256, wheelHorizon}
+
+func (c *TTLCache[K, V]) initWheels() {
+	c.wheel[0] = make([]slot[K, V], 512) // wheel 0 -> 1 ms slots, 512 ms total
+	c.wheel[1] = make([]slot[K, V], 256) // wheel 1 -> 512 ms slots, 131 s total
+	c.wheel[2] = make([]slot[K, V], 256) // wheel 2 -> 131 s slots, ~9.4 h total
+
+	for i := 0; i < 3; i++ {
+		for j := range c.wheel[i] {
+			headDummy := &Node[K, V]{}
+			tailDummy := &Node[K, V]{}
+			headDummy.next = tailDummy
+			tailDummy.prev = headDummy
+
+			c.wheel[i][j].head = headDummy
+			c.wheel[i][j].tail = tailDummy
+			c.wheel[i][j].level = i
+		}
+	}
+	c.overflow.head = &Node[K, V]{}
+	c.overflow.tail = &Node[K, V]{}
+	c.overflow.head.next = c.overflow.tail
+	c.overflow.tail.prev = c.overflow.head
+	c.overflow.level = overflowLevel
+}
+
+// catches the wheel up with the clock however many ticks were missed,
+// stretches where the finer wheels are empty are skipped in one step
+func (c *TTLCache[K, V]) sync(now time.Time) {
+	target := c.tickAt(now)
+	for c.tick < target {
+		// jump to the next boundary of the finest level holding anything
+		next := target
+		for level, n := range c.count {
+			if n > 0 {
+				next = (c.tick/wheelRes[level] + 1) * wheelRes[level]
+				break
+			}
+		}
+		if next > target {
+			next = target
+		}
+		c.tick = next - 1
+		c.advance()
+	}
+}
+
+func (c *TTLCache[K, V]) tickAt(now time.Time) uint64 {
+	if d := now.Sub(c.start); d > 0 {
+		return uint64(d / time.Millisecond)
+	}
+	return 0
+}
+
+// the timer only runs while some slot has entries and sleeps until the
+// first of them comes due, an idle cache never wakes up
+func (c *TTLCache[K, V]) onTimer() {
+	c.mu.Lock()
+	defer c.unlock()
+	if !c.running {
+		return
+	}
+	c.wakeAt = 0 // keeps insertEntry from rearming mid sync
+	c.sync(c.clock.Now())
+	c.wakeAt = neverTick
+	if due, ok := c.nextDue(); ok {
+		c.armAt(due)
+	}
+}
+
+func (c *TTLCache[K, V]) armAt(due uint64) {
+	c.wakeAt = due
+	d := c.start.Add(time.Duration(due) * time.Millisecond).Sub(c.clock.Now())
+	if c.timer == nil {
+		c.timer = c.clock.AfterFunc(d, c.onTimer)
+	} else {
+		c.timer.Reset(d)
+	}
+}
+
+// first tick at which a non-empty slot gets processed
+func (c *TTLCache[K, V]) nextDue() (uint64, bool) {
+	due := neverTick
+	for level, n := range c.count {
+		if n == 0 {
+			continue
+		}
+		res := wheelRes[level]
+		t := (c.tick/res + 1) * res
+		if level == overflowLevel {
+			due = min(due, t)
+			continue
+		}
+		for i := 0; i < len(c.wheel[level]); i++ {
+			if s := c.slotFor(level, t); s.head.next != s.tail {
+				due = min(due, t)
+				break
+			}
+			t += res
+		}
+	}
+	return due, due != neverTick
+}
+
+// moves the wheel forward by one tick, caller must hold the write lock
+func (c *TTLCache[K, V]) advance() {
+	c.tick++
+	// coarse slots are cascaded highest level first so entries falling out
+	// of wheel 2 can land in the wheel 1 slot that is cascaded right after
+	for level := overflowLevel; level > 0; level-- {
+		if c.tick%wheelRes[level] == 0 {
+			c.cascade(level)
+		}
+	}
+	c.expireSlot()
+}
+
+// redistributes the coarse slot that just came due into the finer wheels
+func (c *TTLCache[K, V]) cascade(level int) {
+	s := c.slotFor(level, c.tick)
+	// detached first, entries still past the horizon go back onto the
+	// overflow list and would otherwise be walked again forever
+	e := s.head.next
+	if e == s.tail {
+		return
+	}
+	s.tail.prev.next = nil
+	s.head.next, s.tail.prev = s.tail, s.head
+	for e != nil {
+		next := e.next
+		e.prev, e.next, e.slot = nil, nil, nil
+		c.count[level]--
+		c.insertEntry(e)
+		e = next
+	}
+}
+
+func (c *TTLCache[K, V]) expireSlot() {
+	s := c.slotFor(0, c.tick)
+	for e := s.head.next; e != s.tail; {
+		next := e.next
+		if e.expireTick <= c.tick && !e.stale && !e.missing && c.grace() > 0 {
+			// hold it for the grace window instead of dropping it
+			c.unlink(e)
+			e.stale = true
+			e.expireTick = c.tick + ticksFor(c.grace())
+			c.insertEntry(e)
+			if c.evict != nil {
+				c.evict.fix(e)
+			}
+		} else if e.expireTick <= c.tick {
+			c.removeEntry(e)
+			if c.listening() && !e.missing {
+				c.expired = append(c.expired, e)
+			}
+		}
+		e = next
+	}
+}
+
+func (c *TTLCache[K, V]) slotFor(level int, tick uint64) *slot[K, V] {
+	if level == overflowLevel {
+		return &c.overflow
+	}
+	w := c.wheel[level]
+	return &w[(tick/wheelRes[level])%uint64(len(w))]
+}
+
+// takes the entry off its wheel slot, if it sits in one
+func (c *TTLCache[K, V]) unlink(e *Node[K, V]) {
+	if e.slot != nil {
+		e.slot.remove(e)
+		c.count[e.slot.level]--
+		e.slot = nil
+	}
+}
+
+func (s *slot[K, V]) add(e *Node[K, V]) {
+	previous := s.tail.prev
+	previous.next = e
+	e.prev = previous
+	e.next = s.tail
+	s.tail.prev = e
+}
+
+func (s *slot[K, V]) remove(e *Node[K, V]) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	e.prev = nil
+	e.next = nil
+}
+
+// ttl rounded up to whole ticks, at least one so the entry never lands in
+// the slot that has already been processed for the current tick
+func ticksFor(ttl time.Duration) uint64 {
+	if ttl <= time.Millisecond {
+		return 1
+	}
+	return uint64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// places the entry in the finest wheel whose range covers its expiry tick
+func (c *TTLCache[K, V]) insertEntry(e *Node[K, V]) {
+	if e.expireTick < c.tick {
+		e.expireTick = c.tick
+	}
+	delta := e.expireTick - c.tick
+
+	level := 0
+	if delta >= wheelHorizon {
+		level = overflowLevel
+	} else if delta >= wheelRes[2] {
+		level = 2
+	} else if delta >= wheelRes[1] {
+		level = 1
+	}
+
+	slot := c.slotFor(level, e.expireTick)
+	slot.add(e)
+	e.slot = slot
+	c.count[level]++
+
+	// a coarse slot comes due at the start of its range, the overflow list
+	// at the next horizon boundary
+	due := e.expireTick / wheelRes[level] * wheelRes[level]
+	if level == overflowLevel {
+		due = (c.tick/wheelHorizon + 1) * wheelHorizon
+	}
+	if c.running && due < c.wakeAt {
+		c.armAt(due)
+	}
+}
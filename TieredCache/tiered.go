@@ -0,0 +1,171 @@
+package main
+
+import "sync"
+
+// Level is one cache in the hierarchy. LRUCache, LFUCache and MRUCache all
+// fit, OnEvict is how the tiers hear about each other's evictions.
+type Level[K comparable, V any] interface {
+	Get(key K) (V, bool)
+	Put(key K, value V)
+	Remove(key K) bool
+	OnEvict(fn func(key K, value V))
+}
+
+// Mode decides whether L2 also holds what L1 holds.
+type Mode int
+
+const (
+	// Exclusive keeps every entry in exactly one level, L2 only holds what
+	// L1 evicted, so the hierarchy stores the sum of both capacities.
+	Exclusive Mode = iota
+	// Inclusive writes through to L2 and keeps L1 a subset of it, an entry
+	// evicted from L2 is invalidated in L1 too.
+	Inclusive
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Exclusive:
+		return "exclusive"
+	case Inclusive:
+		return "inclusive"
+	}
+	return "unknown"
+}
+
+// Stats counts lookups per level and the traffic between them.
+type Stats struct {
+	L1Hits        int64
+	L2Hits        int64
+	Misses        int64
+	Promotions    int64 // L2 hits copied or moved into L1
+	Demotions     int64 // L1 evictions moved into L2, exclusive only
+	Invalidations int64 // L1 entries dropped with their L2 copy, inclusive only
+	Drops         int64 // entries evicted from L2, gone from the hierarchy
+}
+
+// L1HitRatio is the share of all lookups answered by L1.
+func (s Stats) L1HitRatio() float64 {
+	return ratio(s.L1Hits, s.L1Hits+s.L2Hits+s.Misses)
+}
+
+// L2HitRatio is the share of L1 misses answered by L2.
+func (s Stats) L2HitRatio() float64 {
+	return ratio(s.L2Hits, s.L2Hits+s.Misses)
+}
+
+// HitRatio is the share of all lookups answered by either level.
+func (s Stats) HitRatio() float64 {
+	return ratio(s.L1Hits+s.L2Hits, s.L1Hits+s.L2Hits+s.Misses)
+}
+
+func ratio(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+type Option func(*options)
+
+type options struct {
+	mode Mode
+}
+
+// WithMode picks inclusive or exclusive levels, exclusive by default.
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// TieredCache chains a small fast L1 in front of a larger L2. An L2 hit is
+// promoted into L1, and depending on the mode an L1 eviction is demoted into
+// L2. The levels shouldn't be used directly once they are chained.
+type TieredCache[K comparable, V any] struct {
+	mu     sync.Mutex
+	l1, l2 Level[K, V]
+	mode   Mode
+	stats  Stats
+}
+
+// NewTieredCache takes over the eviction callbacks of both levels.
+func NewTieredCache[K comparable, V any](l1, l2 Level[K, V], opts ...Option) *TieredCache[K, V] {
+	o := options{mode: Exclusive}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &TieredCache[K, V]{l1: l1, l2: l2, mode: o.mode}
+	l1.OnEvict(c.demote)
+	l2.OnEvict(c.drop)
+	return c
+}
+
+// called by L1 from inside Put, so with c.mu held. In inclusive mode L2
+// already holds the entry and putting it again would count as a use.
+func (c *TieredCache[K, V]) demote(key K, value V) {
+	if c.mode == Inclusive {
+		return
+	}
+	c.stats.Demotions++
+	c.l2.Put(key, value)
+}
+
+// called by L2 from inside Put, so with c.mu held
+func (c *TieredCache[K, V]) drop(key K, _ V) {
+	c.stats.Drops++
+	if c.mode == Inclusive && c.l1.Remove(key) {
+		c.stats.Invalidations++
+	}
+}
+
+func (c *TieredCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.l1.Get(key); ok {
+		c.stats.L1Hits++
+		return v, true
+	}
+	v, ok := c.l2.Get(key)
+	if !ok {
+		c.stats.Misses++
+		return v, false
+	}
+	c.stats.L2Hits++
+	c.stats.Promotions++
+	if c.mode == Exclusive {
+		c.l2.Remove(key)
+	}
+	c.l1.Put(key, v)
+	return v, true
+}
+
+func (c *TieredCache[K, V]) Put(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode == Inclusive {
+		// L2 first, so an eviction it causes can't invalidate the new entry
+		c.l2.Put(key, value)
+	} else {
+		c.l2.Remove(key)
+	}
+	c.l1.Put(key, value)
+}
+
+func (c *TieredCache[K, V]) Remove(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	inL1 := c.l1.Remove(key)
+	inL2 := c.l2.Remove(key)
+	return inL1 || inL2
+}
+
+func (c *TieredCache[K, V]) Mode() Mode {
+	return c.mode
+}
+
+func (c *TieredCache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"cacheEvicitonPolicies/internal/cachetest"
+)
+
+func TestTieredCache_Exclusive(t *testing.T) {
+	l1, l2 := cachetest.NewLRU[string, int](2), cachetest.NewLRU[string, int](3)
+	c := NewTieredCache[string, int](l1, l2)
+
+	for i := 0; i < 5; i++ {
+		c.Put(strconv.Itoa(i), i)
+	}
+	// 3 and 4 in L1, the three older ones demoted to L2
+	for i := 0; i < 5; i++ {
+		key := strconv.Itoa(i)
+		if l1.Has(key) == l2.Has(key) {
+			t.Errorf("key %s: expected in exactly one level", key)
+		}
+	}
+
+	if v, ok := c.Get("0"); !ok || v != 0 {
+		t.Fatalf("expected 0 from L2 but got %v %v", v, ok)
+	}
+	if !l1.Has("0") || l2.Has("0") {
+		t.Errorf("expected 0 moved into L1")
+	}
+	if !l2.Has("3") {
+		t.Errorf("expected 3 pushed down into L2 to make room")
+	}
+	// L2 is full again, demoting 4 drops its oldest, 1, out of the hierarchy
+	c.Put("5", 5)
+	if !l2.Has("4") || l2.Has("1") {
+		t.Errorf("expected 4 demoted and 1 dropped")
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("expected 1 gone from the hierarchy")
+	}
+	c.Get("0")
+
+	want := Stats{L1Hits: 1, L2Hits: 1, Misses: 1, Promotions: 1, Demotions: 5, Drops: 1}
+	if got := c.Stats(); got != want {
+		t.Errorf("expected %+v but got %+v", want, got)
+	}
+}
+
+func TestTieredCache_Inclusive(t *testing.T) {
+	l1, l2 := cachetest.NewLRU[string, int](2), cachetest.NewLRU[string, int](3)
+	c := NewTieredCache[string, int](l1, l2, WithMode(Inclusive))
+
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Put("c", 3)
+	if !l2.Has("a") || !l2.Has("b") || !l2.Has("c") {
+		t.Errorf("expected every Put written through to L2")
+	}
+	if l1.Has("a") {
+		t.Errorf("expected a evicted from L1")
+	}
+
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a from L2 but got %v %v", v, ok)
+	}
+	if !l1.Has("a") || !l2.Has("a") {
+		t.Errorf("expected a copied into L1 and kept in L2")
+	}
+
+	// L1 hits don't reach L2, so c ages there while it is hot in L1
+	c.Get("a")
+	c.Get("c")
+	c.Put("d", 4) // drops b, only in L2
+	c.Put("e", 5) // drops c
+	if l1.Has("c") || l2.Has("c") {
+		t.Errorf("expected c invalidated in L1 when L2 dropped it")
+	}
+	for _, key := range l1.Keys() {
+		if !l2.Has(key) {
+			t.Errorf("expected L1 a subset of L2, %s only in L1", key)
+		}
+	}
+
+	want := Stats{L1Hits: 2, L2Hits: 1, Promotions: 1, Invalidations: 1, Drops: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("expected %+v but got %+v", want, got)
+	}
+}
+
+func TestTieredCache_PutAndRemove(t *testing.T) {
+	for _, mode := range []Mode{Exclusive, Inclusive} {
+		t.Run(mode.String(), func(t *testing.T) {
+			l1, l2 := cachetest.NewLRU[string, int](1), cachetest.NewLRU[string, int](4)
+			c := NewTieredCache[string, int](l1, l2, WithMode(mode))
+			c.Put("a", 1)
+			c.Put("b", 2)
+			c.Put("a", 10)
+			if v, _ := c.Get("a"); v != 10 {
+				t.Errorf("expected the new value but got %d", v)
+			}
+			if v, ok := l2.Peek("a"); ok && v != 10 {
+				t.Errorf("expected no stale copy in L2 but found %d", v)
+			}
+			if !c.Remove("b") || c.Remove("b") {
+				t.Errorf("expected b removed exactly once")
+			}
+			if _, ok := c.Get("b"); ok {
+				t.Errorf("expected b gone from both levels")
+			}
+		})
+	}
+}
+
+func TestStats_Ratios(t *testing.T) {
+	s := Stats{L1Hits: 6, L2Hits: 3, Misses: 1}
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"L1", s.L1HitRatio(), 0.6},
+		{"L2", s.L2HitRatio(), 0.75},
+		{"overall", s.HitRatio(), 0.9},
+		{"empty", Stats{}.HitRatio(), 0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.want, tt.got)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package atomicfile
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	writeString := func(s string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, s)
+			return err
+		}
+	}
+
+	if err := Write(path, writeString("old")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	errBoom := errors.New("boom")
+	if err := Write(path, func(w io.Writer) error {
+		io.WriteString(w, "half")
+		return errBoom
+	}); !errors.Is(err, errBoom) {
+		t.Fatalf("expected the write error but got %v", err)
+	}
+
+	if got, _ := os.ReadFile(path); string(got) != "old" {
+		t.Errorf("expected a failed write to keep the old contents but got %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected the temp file cleaned up but found %d files", len(entries))
+	}
+	if err := Write(path, writeString("new")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "new" {
+		t.Errorf("expected the new contents but got %q", got)
+	}
+}
@@ -0,0 +1,85 @@
+// Package cachetest holds stand-ins for the caches in this repo, which live
+// in package main directories of their own and can't be imported by tests
+// composing them.
+package cachetest
+
+import "container/list"
+
+// LRU is a minimal least recently used cache with the Get, Put, Remove and
+// OnEvict methods LRUCache has.
+type LRU[K comparable, V any] struct {
+	capacity int
+	items    map[K]*list.Element
+	order    *list.List
+	onEvict  func(key K, value V)
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func NewLRU[K comparable, V any](capacity int) *LRU[K, V] {
+	return &LRU[K, V]{capacity: capacity, items: map[K]*list.Element{}, order: list.New()}
+}
+
+func (l *LRU[K, V]) Get(key K) (V, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*entry[K, V]).value, true
+}
+
+func (l *LRU[K, V]) Put(key K, value V) {
+	if el, ok := l.items[key]; ok {
+		el.Value.(*entry[K, V]).value = value
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.order.PushFront(&entry[K, V]{key, value})
+	if l.order.Len() > l.capacity {
+		e := l.order.Remove(l.order.Back()).(*entry[K, V])
+		delete(l.items, e.key)
+		if l.onEvict != nil {
+			l.onEvict(e.key, e.value)
+		}
+	}
+}
+
+func (l *LRU[K, V]) Remove(key K) bool {
+	el, ok := l.items[key]
+	if ok {
+		l.order.Remove(el)
+		delete(l.items, key)
+	}
+	return ok
+}
+
+func (l *LRU[K, V]) OnEvict(fn func(key K, value V)) { l.onEvict = fn }
+
+// Peek returns the value for key without touching its recency.
+func (l *LRU[K, V]) Peek(key K) (V, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return el.Value.(*entry[K, V]).value, true
+}
+
+func (l *LRU[K, V]) Has(key K) bool {
+	_, ok := l.items[key]
+	return ok
+}
+
+// Keys lists the keys held, most recently used first.
+func (l *LRU[K, V]) Keys() []K {
+	keys := make([]K, 0, l.order.Len())
+	for el := l.order.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*entry[K, V]).key)
+	}
+	return keys
+}
@@ -0,0 +1,122 @@
+// Package clock is the time source shared by the caches, with a fake clock
+// for driving expiry in tests.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source a cache expires entries against. Tests swap in a
+// Fake so expiry can be driven without sleeping.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the part of *time.Timer the caches rely on.
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// Real is the wall clock, every cache uses it unless told otherwise.
+var Real Clock = realClock{}
+
+// Fake only moves when Advance is called. Timers that come due fire in
+// order on the goroutine calling Advance, with Now reading their deadline.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	when   time.Time
+	f      func()
+	active bool
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		t := c.nextTimer(end)
+		if t == nil {
+			break
+		}
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		t.active = false
+		// the callback may stop or reset timers, so it runs unlocked
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// earliest active timer due by end, stopped ones are dropped on the way
+func (c *Fake) nextTimer(end time.Time) *fakeTimer {
+	var next *fakeTimer
+	live := c.timers[:0]
+	for _, t := range c.timers {
+		if !t.active {
+			continue
+		}
+		live = append(live, t)
+		if !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+			next = t
+		}
+	}
+	c.timers = live
+	return next
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wasActive := t.active
+	t.when = c.now.Add(d)
+	if !wasActive {
+		t.active = true
+		c.timers = append(c.timers, t)
+	}
+	return wasActive
+}
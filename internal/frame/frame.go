@@ -0,0 +1,41 @@
+// Package frame wraps records appended to a file so a torn or damaged one
+// is caught on read: each payload is prefixed with its length and crc32,
+// both little endian.
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// HeaderSize is how many bytes a frame adds in front of its payload.
+const HeaderSize = 8
+
+// ErrCorrupt is returned for a frame that is cut short or fails its checksum.
+var ErrCorrupt = errors.New("frame is corrupt")
+
+// Append appends payload, framed, to dst and returns the extended slice.
+func Append(dst, payload []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(payload)))
+	dst = binary.LittleEndian.AppendUint32(dst, crc32.ChecksumIEEE(payload))
+	return append(dst, payload...)
+}
+
+// Next checks the frame at the start of data and returns its payload and the
+// frame's full size. A length running past the end of data is reported as
+// ErrCorrupt rather than trusted.
+func Next(data []byte) (payload []byte, n int, err error) {
+	if len(data) < HeaderSize {
+		return nil, 0, ErrCorrupt
+	}
+	size := binary.LittleEndian.Uint32(data[:4])
+	if uint64(size) > uint64(len(data)-HeaderSize) {
+		return nil, 0, ErrCorrupt
+	}
+	payload = data[HeaderSize : HeaderSize+int(size)]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[4:HeaderSize]) {
+		return nil, 0, ErrCorrupt
+	}
+	return payload, HeaderSize + int(size), nil
+}
@@ -0,0 +1,45 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestNext(t *testing.T) {
+	data := Append(nil, []byte("first"))
+	data = Append(data, nil)
+	data = Append(data, []byte("third"))
+
+	var got []string
+	for len(data) > 0 {
+		payload, n, err := Next(data)
+		if err != nil {
+			t.Fatalf("expected every frame intact but got %v", err)
+		}
+		got = append(got, string(payload))
+		data = data[n:]
+	}
+	if len(got) != 3 || got[0] != "first" || got[1] != "" || got[2] != "third" {
+		t.Errorf("expected the payloads back in order but got %q", got)
+	}
+}
+
+func TestNext_Corrupt(t *testing.T) {
+	good := Append(nil, []byte("payload"))
+	flipped := bytes.Clone(good)
+	flipped[len(flipped)-1] ^= 1
+	huge := bytes.Clone(good)
+	huge[3] = 0xff
+
+	for name, data := range map[string][]byte{
+		"short header": good[:HeaderSize-1],
+		"torn payload": good[:len(good)-1],
+		"bad checksum": flipped,
+		"bad length":   huge,
+	} {
+		if _, _, err := Next(data); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected ErrCorrupt but got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package snapshot holds what every cache's Snapshot and Restore share, the
+// pluggable codec and the header that opens each snapshot.
+package snapshot
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codec turns snapshot records, keys and values included, into bytes and
+// back. Gob and JSON are provided, anything with encoder and decoder streams
+// can be plugged in.
+type Codec interface {
+	Name() string
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+type Encoder interface {
+	Encode(v any) error
+}
+
+type Decoder interface {
+	Decode(v any) error
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string                   { return "gob" }
+func (gobCodec) NewEncoder(w io.Writer) Encoder { return gob.NewEncoder(w) }
+func (gobCodec) NewDecoder(r io.Reader) Decoder { return gob.NewDecoder(r) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                   { return "json" }
+func (jsonCodec) NewEncoder(w io.Writer) Encoder { return json.NewEncoder(w) }
+func (jsonCodec) NewDecoder(r io.Reader) Decoder { return json.NewDecoder(r) }
+
+var (
+	Gob  Codec = gobCodec{}
+	JSON Codec = jsonCodec{}
+)
+
+var (
+	ErrFormat  = errors.New("not a snapshot of this cache")
+	ErrVersion = errors.New("unsupported snapshot version")
+)
+
+const (
+	magic   = "CSNP"
+	version = 1
+)
+
+// WriteHeader opens a snapshot with the magic, the format version, then the
+// policy and codec names each prefixed by their length in one byte.
+func WriteHeader(w io.Writer, policy string, codec Codec) error {
+	buf := append([]byte(magic), version)
+	for _, name := range []string{policy, codec.Name()} {
+		buf = append(buf, byte(len(name)))
+		buf = append(buf, name...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadHeader checks that r opens with a header WriteHeader wrote for the
+// same policy and codec.
+func ReadHeader(r io.Reader, policy string, codec Codec) error {
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if string(head[:len(magic)]) != magic {
+		return ErrFormat
+	}
+	if v := head[len(magic)]; v != version {
+		return fmt.Errorf("%w: %d", ErrVersion, v)
+	}
+	for _, want := range []string{policy, codec.Name()} {
+		got, err := readName(r)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		if got != want {
+			return fmt.Errorf("%w: written as %q, expected %q", ErrFormat, got, want)
+		}
+	}
+	return nil
+}
+
+func readName(r io.Reader) (string, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	name := make([]byte, n[0])
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// WriteRecords encodes the record count, then every record.
+func WriteRecords[T any](w io.Writer, codec Codec, records []T) error {
+	enc := codec.NewEncoder(w)
+	if err := enc.Encode(len(records)); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRecords decodes what WriteRecords wrote. The count is only trusted as
+// far as records actually follow it, so a corrupt one can't size a huge
+// allocation.
+func ReadRecords[T any](r io.Reader, codec Codec) ([]T, error) {
+	dec := codec.NewDecoder(r)
+	var n int
+	if err := dec.Decode(&n); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("%w: %d records", ErrFormat, n)
+	}
+	var records []T
+	for range n {
+		var rec T
+		if err := dec.Decode(&rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
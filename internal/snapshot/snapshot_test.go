@@ -0,0 +1,55 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type record struct {
+	Key   string
+	Value int
+}
+
+func TestRecords(t *testing.T) {
+	for _, codec := range []Codec{Gob, JSON} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			want := []record{{"a", 1}, {"b", 2}}
+			var buf bytes.Buffer
+			if err := WriteHeader(&buf, "test", codec); err != nil {
+				t.Fatalf("header failed: %v", err)
+			}
+			if err := WriteRecords(&buf, codec, want); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			if err := ReadHeader(&buf, "test", codec); err != nil {
+				t.Fatalf("header rejected: %v", err)
+			}
+			got, err := ReadRecords[record](&buf, codec)
+			if err != nil || len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+				t.Errorf("expected %v but got %v %v", want, got, err)
+			}
+		})
+	}
+}
+
+func TestReadRecords_BadCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		want  error
+	}{
+		{"negative", -1, ErrFormat},
+		{"past the end", 1 << 40, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			JSON.NewEncoder(&buf).Encode(tt.count)
+			if _, err := ReadRecords[record](&buf, JSON); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, err)
+			}
+		})
+	}
+}
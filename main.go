@@ -1,16 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"math/rand"
 	"net/http"
-	"sort"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"cacheEvicitonPolicies/internal/atomicfile"
 )
 
 // the value to be cached
@@ -20,10 +31,29 @@ type Ticker struct {
 	Time   string  `json:"time"`
 }
 
+// how long a price from the source is good for, and how long an unknown
+// symbol is remembered as such
+const (
+	quoteTTL    = 5 * time.Second
+	negativeTTL = 30 * time.Second
+)
+
+var (
+	snapshotPath     = flag.String("snapshot", "cache.snap", "file the cache is persisted to, empty to disable")
+	snapshotInterval = flag.Duration("snapshot-interval", 30*time.Second, "how often the cache is persisted")
+)
+
 var (
+	// LFUCache isn't safe for concurrent use, handlers, metrics and
+	// snapshots all go through cacheMu
+	cacheMu     sync.Mutex
 	cache       *LFUCache[string, Ticker]
 	cacheHits   int64
 	cacheMisses int64
+	notFound    int64
+
+	// loads quotes on a miss and keeps tombstones for unknown symbols
+	quotes *TTLCache[string, Ticker]
 
 	cacheHitsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "cache_hits_total",
@@ -41,11 +71,23 @@ var (
 		Name: "cache_hit_ratio",
 		Help: "Ratio of cache hits.",
 	})
+	notFoundGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "quote_not_found_total",
+		Help: "Total number of lookups for unknown symbols.",
+	})
+	tombstonesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_negative_entries",
+		Help: "Unknown symbols currently remembered by the negative cache.",
+	})
 	reqDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "request_duration_seconds",
 		Help:    "request latency",
 		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
 	}, []string{"symbol", "cache"})
+	warmStartGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_warm_start_entries",
+		Help: "Entries restored from the snapshot at startup.",
+	})
 	topSymbolsGauge = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "top_symbols_requests",
@@ -56,25 +98,65 @@ var (
 )
 
 func init() {
-	prometheus.MustRegister(cacheHitsGauge, cacheMissesGauge, cacheSizeGauge, cacheHitRatioGauge, reqDuration, topSymbolsGauge)
+	prometheus.MustRegister(cacheHitsGauge, cacheMissesGauge, cacheSizeGauge, cacheHitRatioGauge, reqDuration, topSymbolsGauge,
+		notFoundGauge, tombstonesGauge, warmStartGauge)
 }
 func main() {
+	flag.Parse()
 
 	var err error
 	cache, err = NewLFUCache[string, Ticker](10)
 	if err != nil {
 		panic(err)
 	}
+	if *snapshotPath != "" {
+		if err := loadSnapshot(*snapshotPath); err != nil {
+			fmt.Println("starting cold, could not load snapshot:", err)
+		}
+	}
+	quotes, err = NewTTLCache[string, Ticker](WithNegativeTTL(negativeTTL))
+	if err != nil {
+		panic(err)
+	}
+	quotes.SetLoader(fetchQuote)
 
 	http.HandleFunc("/quote", cstmHandler)
 	http.Handle("/metrics", promhttp.Handler())
 
 	go dometrics()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var persisting sync.WaitGroup
+	if *snapshotPath != "" {
+		persisting.Go(func() { persist(ctx, *snapshotPath, *snapshotInterval) })
+	}
+
+	srv := &http.Server{Addr: ":42069"}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			fmt.Println("shutdown:", err)
+		}
+	}()
+
 	fmt.Println("Running at :42069 ...")
-	if err := http.ListenAndServe(":42069", nil); err != nil {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		panic(err)
 	}
+	// ListenAndServe returns as soon as Shutdown starts, the final save waits
+	// for in-flight handlers and for a periodic save that may still be running
+	<-drained
+	persisting.Wait()
+	if *snapshotPath != "" {
+		if err := saveSnapshot(*snapshotPath); err != nil {
+			fmt.Println("final snapshot failed:", err)
+		}
+	}
 }
 
 func cstmHandler(w http.ResponseWriter, r *http.Request) {
@@ -88,23 +170,34 @@ func cstmHandler(w http.ResponseWriter, r *http.Request) {
 	var cacheHit string
 	var t Ticker
 
-	if ticker, ok := cache.Get(symbol); ok {
+	cacheMu.Lock()
+	ticker, ok := cache.Get(symbol)
+	cacheMu.Unlock()
+	if ok {
 		atomic.AddInt64(&cacheHits, 1)
 		cacheHit = "hit"
 		t = ticker
 	} else {
 		atomic.AddInt64(&cacheMisses, 1)
 		cacheHit = "miss"
-		t = Ticker{
-			Symbol: symbol,
-			Price:  1000 + rand.Float64()*50000,
-			Time:   time.Now().Format(time.RFC3339),
+		ticker, _, err := quotes.Fetch(symbol, quoteTTL)
+		if errors.Is(err, ErrNotFound) {
+			atomic.AddInt64(&notFound, 1)
+			http.Error(w, "unknown symbol", http.StatusNotFound)
+			return
 		}
-		cache.Put(symbol, t)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		t = ticker
+		// the ttl travels with snapshots, so a warm start drops old prices
+		cacheMu.Lock()
+		cache.PutWithTTL(symbol, t, quoteTTL)
+		cacheMu.Unlock()
 	}
 	duration := time.Since(start).Seconds()
 	reqDuration.WithLabelValues(symbol, cacheHit).Observe(duration)
-	cache.Put(symbol, t)
 	json.NewEncoder(w).Encode(t)
 }
 
@@ -112,11 +205,15 @@ func dometrics() {
 	for {
 		hits := atomic.LoadInt64(&cacheHits)
 		misses := atomic.LoadInt64(&cacheMisses)
-		sz := len(cache.Cache)
+		cacheMu.Lock()
+		sz := cache.Len()
+		cacheMu.Unlock()
 
 		cacheHitsGauge.Set(float64(hits))
 		cacheMissesGauge.Set(float64(misses))
 		cacheSizeGauge.Set(float64(sz))
+		notFoundGauge.Set(float64(atomic.LoadInt64(&notFound)))
+		tombstonesGauge.Set(float64(quotes.Stats().Tombstones))
 
 		total := float64(hits + misses)
 		if total > 0 {
@@ -131,24 +228,87 @@ func dometrics() {
 	}
 }
 
+// mock quote source, it only lists USDT pairs
+func fetchQuote(symbol string) (Ticker, error) {
+	if !strings.HasSuffix(symbol, "USDT") {
+		return Ticker{}, ErrNotFound
+	}
+	return Ticker{
+		Symbol: symbol,
+		Price:  1000 + rand.Float64()*50000,
+		Time:   time.Now().Format(time.RFC3339),
+	}, nil
+}
+
 func updateTopRequests() {
 	topSymbolsGauge.Reset()
-	items := cache.Items()
-
-	type topFreqVal struct {
-		Key  string
-		Freq int
+	cacheMu.Lock()
+	top := cache.TopK(5)
+	cacheMu.Unlock()
+	for _, item := range top {
+		topSymbolsGauge.WithLabelValues(item.Key).Set(float64(item.Freq))
 	}
-	var topFreqList []topFreqVal
-	for k, v := range items {
-		topFreqList = append(topFreqList, topFreqVal{k, v.Freq})
+}
+
+// persists the cache every interval until ctx is done, the final save on
+// shutdown happens in main once the server has drained and this returned
+func persist(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := saveSnapshot(path); err != nil {
+				fmt.Println("snapshot failed:", err)
+			}
+		}
 	}
+}
 
-	sort.Slice(topFreqList, func(i, j int) bool {
-		return topFreqList[i].Freq > topFreqList[j].Freq
+// writes the snapshot to a temp file next to path and renames it over path,
+// so a crash halfway leaves the previous snapshot intact
+func saveSnapshot(path string) error {
+	return atomicfile.Write(path, func(f io.Writer) error {
+		w := bufio.NewWriter(f)
+		cacheMu.Lock()
+		err := cache.Snapshot(w)
+		cacheMu.Unlock()
+		if err != nil {
+			return err
+		}
+		return w.Flush()
 	})
+}
+
+// warms the cache up from the last snapshot, entries whose ttl ran out while
+// the server was down are dropped by Restore
+func loadSnapshot(path string) error {
+	start := time.Now()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("no snapshot at", path, "starting cold")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
 
-	for i := 0; i < len(topFreqList) && i < 5; i++ {
-		topSymbolsGauge.WithLabelValues(topFreqList[i].Key).Set(float64(topFreqList[i].Freq))
+	cacheMu.Lock()
+	err = cache.Restore(bufio.NewReader(f))
+	n := cache.Len()
+	cacheMu.Unlock()
+	if err != nil {
+		return err
 	}
+	warmStartGauge.Set(float64(n))
+	fmt.Printf("warm start: %d entries from %s, snapshot %s old, loaded in %s\n",
+		n, path, time.Since(info.ModTime()).Round(time.Second), time.Since(start))
+	return nil
 }